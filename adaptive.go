@@ -0,0 +1,55 @@
+package main
+
+import "time"
+
+// adaptiveInterval adjusts the sampling interval for -adaptive: halving it
+// whenever processes started or ended since the previous sample, so bursts
+// are captured in detail, and stretching it by a quarter while the tree is
+// stable, to save overhead during quiet periods.
+type adaptiveInterval struct {
+	min, max, current time.Duration
+}
+
+func newAdaptiveInterval(initial, min, max time.Duration) *adaptiveInterval {
+	a := &adaptiveInterval{min: min, max: max, current: initial}
+	a.clamp()
+	return a
+}
+
+// next returns the interval to wait after a sample, given how many processes
+// started or ended since the one before it.
+func (a *adaptiveInterval) next(churn int) time.Duration {
+	if churn > 0 {
+		a.current /= 2
+	} else {
+		a.current += a.current / 4
+	}
+	a.clamp()
+	return a.current
+}
+
+func (a *adaptiveInterval) clamp() {
+	if a.current < a.min {
+		a.current = a.min
+	}
+	if a.current > a.max {
+		a.current = a.max
+	}
+}
+
+// churn counts the processes that started or ended between two samples,
+// including those that exec'd a new command.
+func churn(before, after sample) int {
+	n := 0
+	for pid, p := range after.Procs {
+		if q, ok := before.Procs[pid]; !ok || q.Command != p.Command {
+			n++
+		}
+	}
+	for pid, p := range before.Procs {
+		if q, ok := after.Procs[pid]; !ok || q.Command != p.Command {
+			n++
+		}
+	}
+	return n + len(after.Events)
+}
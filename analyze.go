@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+)
+
+// runAnalyze implements `pstree_prof analyze run.ndjson`, writing reports
+// from a run recorded with -fmt ndjson as if it had just been profiled.
+func runAnalyze(args []string) {
+	flags := flag.NewFlagSet("analyze", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s analyze [flags] run.ndjson\n", NAME)
+		flags.PrintDefaults()
+	}
+	rf := addReportFlags(flags, true)
+	parseFlags(flags, args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		log.Fatalln("expected exactly one recorded run")
+	}
+	r := rf.reporter(flags)
+	for _, name := range r.formats {
+		if outputFormats[name].stream {
+			log.Fatalf("-fmt %s is only available while sampling\n", name)
+		}
+	}
+
+	samples, err := readSamples(flags.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	r.opts.envKeys, r.opts.columns = recordedKeys(samples)
+	r.write(samples, r.out)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// budgets are the limits set with the -max-* flags. Zero means unlimited.
+type budgets struct {
+	procs       int
+	duration    time.Duration
+	concurrency int
+}
+
+// checkBudgets describes every budget that samples exceed, along with the
+// processes responsible.
+func checkBudgets(samples []sample, b budgets) []string {
+	var violations []string
+	if len(samples) == 0 {
+		return violations
+	}
+
+	if b.procs > 0 {
+		ls := lifetimes(samples)
+		if len(ls) > b.procs {
+			counts := make(map[string]int)
+			for _, l := range ls {
+				counts[l.proc.Command]++
+			}
+			violations = append(violations, fmt.Sprintf("%d processes were started, more than -max-procs %d:\n%s", len(ls), b.procs, formatCommandCounts(counts)))
+		}
+	}
+
+	if b.duration > 0 {
+		elapsed := samples[len(samples)-1].At.Sub(samples[0].At)
+		if elapsed > b.duration {
+			// blame whatever was still running when the budget ran out
+			deadline := samples[0].At.Add(b.duration)
+			counts := make(map[string]int)
+			for _, l := range lifetimes(samples) {
+				if l.end.After(deadline) {
+					counts[l.proc.Command]++
+				}
+			}
+			violations = append(violations, fmt.Sprintf("ran for %s, longer than -max-duration %s; still running at the deadline:\n%s", elapsed.Round(time.Millisecond), b.duration, formatCommandCounts(counts)))
+		}
+	}
+
+	if b.concurrency > 0 {
+		peakAt := 0
+		for i, s := range samples {
+			if len(s.Procs) > len(samples[peakAt].Procs) {
+				peakAt = i
+			}
+		}
+		if peak := len(samples[peakAt].Procs); peak > b.concurrency {
+			counts := make(map[string]int)
+			for _, p := range samples[peakAt].Procs {
+				counts[p.Command]++
+			}
+			violations = append(violations, fmt.Sprintf("%d processes were running at once (sample %d), more than -max-concurrency %d:\n%s", peak, peakAt, b.concurrency, formatCommandCounts(counts)))
+		}
+	}
+
+	return violations
+}
+
+// formatCommandCounts lists commands with how many processes of each there
+// were, most frequent first.
+func formatCommandCounts(counts map[string]int) string {
+	commands := make([]string, 0, len(counts))
+	for command := range counts {
+		commands = append(commands, command)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		if counts[commands[i]] != counts[commands[j]] {
+			return counts[commands[i]] > counts[commands[j]]
+		}
+		return commands[i] < commands[j]
+	})
+
+	var b strings.Builder
+	for _, command := range commands {
+		fmt.Fprintf(&b, "\t%d\t%s\n", counts[command], command)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// cgroupStats is the resource usage of the whole command, as accounted by the
+// cgroup that the cgroup backend places it in. CPU times are cumulative.
+type cgroupStats struct {
+	CPUUsec         int64 `json:"cpu_usec"`
+	UserUsec        int64 `json:"user_usec"`
+	SystemUsec      int64 `json:"system_usec"`
+	MemoryBytes     int64 `json:"memory_bytes,omitempty"`
+	MemoryPeakBytes int64 `json:"memory_peak_bytes,omitempty"`
+}
+
+// printCgroupStats reports the CPU time used by the command's cgroup over the
+// run, and the most memory it used.
+func printCgroupStats(w io.Writer, samples []sample, opts reportOptions) error {
+	var first, last *cgroupStats
+	var firstAt, lastAt time.Time
+	var peakMemory int64
+	for _, s := range samples {
+		if s.Cgroup == nil {
+			continue
+		}
+		if first == nil {
+			first, firstAt = s.Cgroup, s.At
+		}
+		last, lastAt = s.Cgroup, s.At
+		for _, m := range []int64{s.Cgroup.MemoryBytes, s.Cgroup.MemoryPeakBytes} {
+			if m > peakMemory {
+				peakMemory = m
+			}
+		}
+	}
+	if first == nil {
+		fmt.Fprintln(w, "no cgroup statistics were sampled (use -backend cgroup, on Linux)")
+		return nil
+	}
+
+	usec := func(v int64) time.Duration { return time.Duration(v) * time.Microsecond }
+	wall := lastAt.Sub(firstAt)
+	cpu := usec(last.CPUUsec - first.CPUUsec)
+	fmt.Fprintf(w, "wall:\t%s\n", wall.Round(time.Millisecond))
+	fmt.Fprintf(w, "cpu:\t%s (user %s, system %s)\n", cpu.Round(time.Millisecond), usec(last.UserUsec-first.UserUsec).Round(time.Millisecond), usec(last.SystemUsec-first.SystemUsec).Round(time.Millisecond))
+	if wall > 0 {
+		fmt.Fprintf(w, "cpu/wall:\t%.2f\n", cpu.Seconds()/wall.Seconds())
+	}
+	if peakMemory > 0 {
+		fmt.Fprintf(w, "peak memory:\t%d bytes\n", peakMemory)
+	} else {
+		fmt.Fprintln(w, "peak memory:\tunknown (memory controller not enabled)")
+	}
+	return nil
+}
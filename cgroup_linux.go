@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func init() {
+	procListers["cgroup"] = func() procLister { return &cgroupLister{psLister: psLister{threads: true}} }
+	if dir, ok := os.LookupEnv(cgroupStubEnv); ok {
+		execInCgroup(dir, os.Getenv(cgroupStubPathEnv))
+	}
+}
+
+// pstree_prof started with cgroupStubEnv set moves itself into the cgroup it
+// names, then execs the program at cgroupStubPathEnv with its own arguments.
+const (
+	cgroupStubEnv     = "PSTREE_PROF_CGROUP"
+	cgroupStubPathEnv = "PSTREE_PROF_CGROUP_EXEC"
+)
+
+// cgroupLister moves the command into a fresh cgroup (v2 only) beneath our
+// own, so that every descendant is tracked no matter how it was reparented,
+// and records the cgroup's CPU and memory usage with each sample. Creating the
+// cgroup requires write access to our cgroup, e.g. via systemd delegation.
+type cgroupLister struct {
+	psLister
+	dir string
+}
+
+func (l *cgroupLister) withPsOptions(opts psOptions) procLister {
+	return &cgroupLister{psLister: l.psLister.withPsOptions(opts).(psLister), dir: l.dir}
+}
+
+// prepare creates the cgroup and has cmd start as a stub that moves itself
+// into it and then execs the command, so that nothing the command starts can
+// escape the cgroup, however quickly it forks.
+func (l *cgroupLister) prepare(cmd *exec.Cmd) error {
+	parent, err := ownCgroupDir()
+	if err != nil {
+		return err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("could not find %s to move the command into a cgroup: %s", NAME, err)
+	}
+	dir, err := os.MkdirTemp(parent, NAME+"-*")
+	if err != nil {
+		return fmt.Errorf("could not create cgroup: %s", err)
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env, cgroupStubEnv+"="+dir, cgroupStubPathEnv+"="+cmd.Path)
+	// the stub keeps the command's arguments, so that it's sampled as the
+	// command if it's seen before it execs
+	cmd.Path = self
+	l.dir = dir
+	return nil
+}
+
+// execInCgroup is the stub started by prepare: it moves itself into the
+// cgroup at dir and then execs the program at path. It exits with the code a
+// shell would if it can't.
+func execInCgroup(dir, path string) {
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: could not move command into cgroup %s: %s\n", NAME, dir, err)
+		os.Exit(126)
+	}
+	os.Unsetenv(cgroupStubEnv)
+	os.Unsetenv(cgroupStubPathEnv)
+	err := syscall.Exec(path, os.Args, os.Environ())
+	fmt.Fprintf(os.Stderr, "%s: could not run %s: %s\n", NAME, path, err)
+	os.Exit(127)
+}
+
+// scope does nothing, since the command moves itself into the cgroup.
+func (l *cgroupLister) scope(cmd *exec.Cmd) error {
+	return nil
+}
+
+func (l *cgroupLister) scopedPids() ([]int, error) {
+	data, err := os.ReadFile(filepath.Join(l.dir, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// annotate records the cgroup's resource usage. Memory usage is only
+// available if the memory controller is enabled for our cgroup's children.
+func (l *cgroupLister) annotate(s *sample) {
+	stats := &cgroupStats{}
+	if f, err := os.Open(filepath.Join(l.dir, "cpu.stat")); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 2 {
+				continue
+			}
+			value, _ := strconv.ParseInt(fields[1], 10, 64)
+			switch fields[0] {
+			case "usage_usec":
+				stats.CPUUsec = value
+			case "user_usec":
+				stats.UserUsec = value
+			case "system_usec":
+				stats.SystemUsec = value
+			}
+		}
+		f.Close()
+	}
+	stats.MemoryBytes = readCgroupInt(filepath.Join(l.dir, "memory.current"))
+	stats.MemoryPeakBytes = readCgroupInt(filepath.Join(l.dir, "memory.peak"))
+	s.Cgroup = stats
+}
+
+// Close removes the cgroup, which fails if any processes are still in it.
+func (l *cgroupLister) Close() error {
+	if l.dir == "" {
+		return nil
+	}
+	if err := os.Remove(l.dir); err != nil {
+		return fmt.Errorf("could not remove cgroup (are processes still running in it?): %s", err)
+	}
+	return nil
+}
+
+func readCgroupInt(path string) int64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	value, _ := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return value
+}
+
+// ownCgroupDir finds the directory of our cgroup in the cgroup v2 hierarchy.
+func ownCgroupDir() (string, error) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	path := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			path = strings.TrimPrefix(line, "0::")
+		}
+	}
+	if path == "" {
+		return "", fmt.Errorf("not running in a cgroup v2 hierarchy")
+	}
+
+	mounts, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(mounts), "\n") {
+		// mountinfo lines are "id parent dev root mountpoint options... - fstype source options"
+		sep := strings.Index(line, " - ")
+		if sep < 0 {
+			continue
+		}
+		fields, after := strings.Fields(line[:sep]), strings.Fields(line[sep+3:])
+		if len(fields) >= 5 && len(after) > 0 && after[0] == "cgroup2" {
+			return filepath.Join(fields[4], path), nil
+		}
+	}
+	return "", fmt.Errorf("cgroup2 filesystem is not mounted")
+}
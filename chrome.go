@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+// chromeEvent is an event in the Trace Event Format read by chrome://tracing
+// and Perfetto's legacy JSON importer.
+type chromeEvent struct {
+	Name  string                 `json:"name"`
+	Cat   string                 `json:"cat,omitempty"`
+	Phase string                 `json:"ph"`
+	Ts    float64                `json:"ts"`
+	Dur   float64                `json:"dur"`
+	Pid   int                    `json:"pid"`
+	Tid   int                    `json:"tid"`
+	ID    int                    `json:"id,omitempty"`
+	Scope string                 `json:"s,omitempty"`
+	Bind  string                 `json:"bp,omitempty"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+}
+
+// exportSamplesAsChromeTrace writes a chrome://tracing JSON trace. Each
+// process is shown as a process with a single thread, both named by its
+// command, holding a slice for its lifetime with its argv and user in the
+// args. Flow arrows lead from each parent to the children it spawned, and
+// lines recorded with -tee and the -timeout are global instant events.
+//
+// argv is the command split on whitespace, since backends don't preserve how
+// the arguments were quoted. The trace format can't express a pid being
+// reused, so a reused pid shows all of its processes under the name of the
+// last one.
+func exportSamplesAsChromeTrace(w io.Writer, samples []sample, opts reportOptions) error {
+	events := []chromeEvent{}
+	if len(samples) > 0 {
+		start := samples[0].At
+		ts := func(t time.Time) float64 {
+			return float64(t.Sub(start).Nanoseconds()) / 1e3
+		}
+
+		ls := lifetimes(samples)
+		parents := parentLifetimes(ls)
+		for i, l := range ls {
+			p := l.proc
+			name := normalizeCommand(p.Command, opts.normalize)
+			args := map[string]interface{}{
+				"argv":    strings.Fields(p.Command),
+				"user":    p.User,
+				"ppid":    p.Ppid,
+				"pgid":    p.Pgid,
+				"samples": l.samples(),
+			}
+			for _, key := range opts.envKeys {
+				if value, ok := p.Env[key]; ok {
+					args["env."+key] = value
+				}
+			}
+			events = append(events,
+				chromeEvent{Name: "process_name", Phase: "M", Pid: p.Pid, Tid: p.Pid, Args: map[string]interface{}{"name": name}},
+				chromeEvent{Name: "thread_name", Phase: "M", Pid: p.Pid, Tid: p.Pid, Args: map[string]interface{}{"name": p.Command}},
+				chromeEvent{Name: "process_sort_index", Phase: "M", Pid: p.Pid, Tid: p.Pid, Args: map[string]interface{}{"sort_index": i}},
+				chromeEvent{Name: name, Cat: "process", Phase: "X", Ts: ts(l.start), Dur: ts(l.end) - ts(l.start), Pid: p.Pid, Tid: p.Pid, Args: args},
+			)
+			if parents[i] >= 0 {
+				// the flow starts inside the parent's slice and binds to
+				// the enclosing slice of the child
+				parent := ls[parents[i]].proc
+				events = append(events,
+					chromeEvent{Name: "spawn", Cat: "spawn", Phase: "s", Ts: ts(l.start), Pid: parent.Pid, Tid: parent.Pid, ID: i + 1},
+					chromeEvent{Name: "spawn", Cat: "spawn", Phase: "f", Bind: "e", Ts: ts(l.start), Pid: p.Pid, Tid: p.Pid, ID: i + 1},
+				)
+			}
+		}
+
+		if i, ok := firstTimedOut(samples); ok {
+			events = append(events, chromeEvent{Name: "timed out", Phase: "i", Scope: "g", Ts: ts(samples[i].At)})
+		}
+		for _, s := range samples {
+			for _, line := range s.Output {
+				events = append(events, chromeEvent{Name: line.Stream, Cat: "output", Phase: "i", Scope: "g", Ts: ts(line.At), Args: map[string]interface{}{
+					"text": line.Text,
+					"live": line.Live,
+				}})
+			}
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	return enc.Encode(struct {
+		TraceEvents     []chromeEvent `json:"traceEvents"`
+		DisplayTimeUnit string        `json:"displayTimeUnit"`
+	}{events, "ms"})
+}
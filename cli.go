@@ -0,0 +1,213 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// subcommand is one of the workflows pstree_prof supports.
+type subcommand struct {
+	run func(args []string)
+	// summary is a one-line description for the top-level usage
+	summary string
+}
+
+// subcommands are looked up by the first argument. Anything else is taken to
+// be the flags of `run`, which is how pstree_prof was invoked before it had
+// subcommands.
+var subcommands = map[string]subcommand{
+	"run":     {run: runProfile, summary: "run a command and report on the processes it starts"},
+	"analyze": {run: runAnalyze, summary: "write reports from a run recorded with -fmt ndjson"},
+	"report":  {run: runAnalyze, summary: "the same as analyze"},
+	"diff":    {run: runDiff, summary: "compare two runs recorded with -fmt ndjson"},
+	"serve":   {run: runServe, summary: "serve the reports of a run recorded with -fmt ndjson over HTTP"},
+}
+
+func main() {
+	log.SetPrefix(fmt.Sprintf("%s: ", NAME))
+	args := os.Args[1:]
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		if len(args) > 1 {
+			if sub, ok := subcommands[args[1]]; ok {
+				sub.run([]string{"-h"})
+				return
+			}
+		}
+		usage()
+		return
+	}
+	if sub, ok := subcommands[args[0]]; ok {
+		sub.run(args[1:])
+		return
+	}
+	runProfile(args)
+}
+
+func usage() {
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := os.Stderr
+	fmt.Fprintf(w, "usage: %s <command> [flags] [args...]\n\ncommands:\n", NAME)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-8s %s\n", name, subcommands[name].summary)
+	}
+	fmt.Fprintf(w, "\n%s [flags] -- command [args...] is short for %s run. Run %s help <command> for its flags.\n", NAME, NAME, NAME)
+}
+
+// reportFlags are the flags shared by the subcommands that write reports.
+type reportFlags struct {
+	// outputFmt and outPath are nil for subcommands that choose the
+	// formats some other way
+	outputFmt, outPath *string
+
+	include, exclude, normalize *string
+	otlpEndpoint                *string
+	otlpInsecure                *bool
+	collapse                    *bool
+	wrappers                    *string
+	showAncestry                *bool
+	shortLived                  *int
+	templateFile                *string
+}
+
+// addReportFlags defines the report flags on flags, including -fmt and -o if
+// outputs is set.
+func addReportFlags(flags *flag.FlagSet, outputs bool) *reportFlags {
+	f := &reportFlags{}
+	if outputs {
+		f.outputFmt = flags.String("fmt", "count", "Comma-separated output formats to summarize samples: "+strings.Join(formatNames(), ", "))
+		f.outPath = flags.String("o", "", "Write the report to this file instead of stdout/stderr. With several formats, each is written to <path>.<format>")
+	}
+	f.include = flags.String("include", "", "Only report processes whose command matches this regexp")
+	f.exclude = flags.String("exclude", "", "Don't report processes whose command matches this regexp")
+	f.normalize = flags.String("normalize", "", "Regexp used by -fmt groups and summary to extract the grouping key from a command (default: base name of the executable)")
+	f.otlpEndpoint = flags.String("otlp-endpoint", "", "host:port of the OTLP/HTTP collector used by -fmt otlp (default: from OTEL_EXPORTER_OTLP_ENDPOINT, or localhost:4318)")
+	f.otlpInsecure = flags.Bool("otlp-insecure", false, "Use plain HTTP rather than HTTPS for -fmt otlp")
+	f.collapse = flags.Bool("collapse-wrappers", false, "Leave out processes that only wrap others, such as sh -c and env, reparenting their children, so reports reflect the commands doing the work")
+	f.wrappers = flags.String("wrappers", defaultWrappers, "Comma-separated commands collapsed by -collapse-wrappers, each an executable name optionally followed by the arguments it must start with")
+	f.showAncestry = flags.Bool("show-ancestry", false, "Label processes in the count and summary reports with their chain of ancestors, e.g. make → sh → cc1")
+	f.shortLived = flags.Int("short-lived", 1, "The most samples a process may be seen in to be reported by -fmt short_lived")
+	f.templateFile = flags.String("template-file", "", "Go text/template to execute for -fmt template")
+	return f
+}
+
+// reporter writes the reports requested with reportFlags.
+type reporter struct {
+	// formats and out are empty unless the subcommand has -fmt and -o
+	formats          []string
+	out              string
+	opts             reportOptions
+	include, exclude *regexp.Regexp
+	wrappers         []wrapper
+}
+
+// reporter checks the parsed report flags, exiting with the usage of flags
+// if they're invalid.
+func (f *reportFlags) reporter(flags *flag.FlagSet) *reporter {
+	r := &reporter{}
+	var err error
+	if r.include, err = compileOptionalRegexp(*f.include); err != nil {
+		log.Fatalf("invalid -include: %s\n", err)
+	}
+	if r.exclude, err = compileOptionalRegexp(*f.exclude); err != nil {
+		log.Fatalf("invalid -exclude: %s\n", err)
+	}
+	normalize, err := compileOptionalRegexp(*f.normalize)
+	if err != nil {
+		log.Fatalf("invalid -normalize: %s\n", err)
+	}
+	if *f.shortLived < 1 {
+		log.Fatalln("-short-lived must be at least 1")
+	}
+	var tmpl *template.Template
+	if *f.templateFile != "" {
+		tmpl, err = loadTemplate(*f.templateFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *f.collapse {
+		r.wrappers = parseWrappers(*f.wrappers)
+	}
+	r.opts = reportOptions{
+		normalize:    normalize,
+		otlpEndpoint: *f.otlpEndpoint,
+		otlpInsecure: *f.otlpInsecure,
+		showAncestry: *f.showAncestry,
+		shortLived:   *f.shortLived,
+		template:     tmpl,
+	}
+
+	if f.outputFmt == nil {
+		return r
+	}
+	r.formats, err = parseFormats(*f.outputFmt)
+	if err != nil {
+		flags.Usage()
+		log.Fatalln(err)
+	}
+	r.out = *f.outPath
+	if err := checkFormatDestinations(r.formats, r.out); err != nil {
+		log.Fatalln(err)
+	}
+	for _, name := range r.formats {
+		if name == "template" && tmpl == nil {
+			log.Fatalln("-fmt template requires -template-file")
+		}
+	}
+	return r
+}
+
+// prepare applies -include, -exclude and -collapse-wrappers to samples.
+func (r *reporter) prepare(samples []sample) []sample {
+	return collapseWrappers(filterSamples(samples, r.include, r.exclude), r.wrappers)
+}
+
+// write writes the reports for samples to out, returning the samples that
+// were reported on.
+func (r *reporter) write(samples []sample, out string) []sample {
+	samples = r.prepare(samples)
+	if err := writeReports(r.formats, out, samples, r.opts); err != nil {
+		fatal(err)
+	}
+	return samples
+}
+
+// recordedKeys returns the environment variables and extra columns captured
+// in samples, for reports on runs recorded earlier.
+func recordedKeys(samples []sample) (envKeys, columns []string) {
+	env, extra := make(map[string]bool), make(map[string]bool)
+	for _, s := range samples {
+		for _, p := range s.Procs {
+			for key := range p.Env {
+				env[key] = true
+			}
+			for col := range p.Extra {
+				extra[col] = true
+			}
+		}
+	}
+	for key := range env {
+		envKeys = append(envKeys, key)
+	}
+	for col := range extra {
+		columns = append(columns, col)
+	}
+	sort.Strings(envKeys)
+	sort.Strings(columns)
+	return envKeys, columns
+}
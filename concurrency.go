@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sparklineWidth is the most characters a sparkline will use; longer runs are
+// bucketed, with each character showing the peak of its bucket.
+const sparklineWidth = 80
+
+// printConcurrency writes the number of live processes in each sample as a
+// CSV time series.
+func printConcurrency(w io.Writer, samples []sample, opts reportOptions) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"sample", "elapsed_seconds", "at", "live"})
+	for i, s := range samples {
+		cw.Write([]string{
+			strconv.Itoa(i),
+			strconv.FormatFloat(s.At.Sub(samples[0].At).Seconds(), 'f', 6, 64),
+			s.At.Format(time.RFC3339Nano),
+			strconv.Itoa(len(s.Procs)),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// printConcurrencySparkline draws the number of live processes over time and
+// reports when it peaked.
+func printConcurrencySparkline(w io.Writer, samples []sample, opts reportOptions) error {
+	if len(samples) == 0 {
+		fmt.Fprintln(w, "no samples")
+		return nil
+	}
+
+	peak, peakAt := 0, 0
+	for i, s := range samples {
+		if len(s.Procs) > peak {
+			peak, peakAt = len(s.Procs), i
+		}
+	}
+
+	buckets := len(samples)
+	if buckets > sparklineWidth {
+		buckets = sparklineWidth
+	}
+	levels := []rune("▁▂▃▄▅▆▇█")
+	var line strings.Builder
+	for b := 0; b < buckets; b++ {
+		live := 0
+		for _, s := range samples[b*len(samples)/buckets : (b+1)*len(samples)/buckets] {
+			if len(s.Procs) > live {
+				live = len(s.Procs)
+			}
+		}
+		level := 0
+		if peak > 0 {
+			level = live * (len(levels) - 1) / peak
+		}
+		line.WriteRune(levels[level])
+	}
+
+	elapsed := samples[len(samples)-1].At.Sub(samples[0].At)
+	fmt.Fprintf(w, "%s\n", line.String())
+	fmt.Fprintf(w, "0s%*s\n", buckets-2, elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "peak of %d live processes at %s (+%s, sample %d)\n",
+		peak, samples[peakAt].At.Format(time.RFC3339Nano), samples[peakAt].At.Sub(samples[0].At).Round(time.Millisecond), peakAt)
+	return nil
+}
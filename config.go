@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultConfigPath is where the config file is read from unless -config
+// says otherwise: $XDG_CONFIG_HOME/pstree_prof/config.toml, or
+// ~/.config/pstree_prof/config.toml.
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, NAME, "config.toml")
+}
+
+// parseFlags parses args into flags after defining -config on it and
+// applying the config file, so that the file's values become the defaults
+// that args can override.
+//
+// Keys at the top of the file set the flag of that name for every
+// subcommand that has one. Keys in a [run], [analyze], ... table only apply
+// to that subcommand, which must have the flag, e.g.
+//
+//	interval = "50ms"
+//	exclude = "^ps "
+//
+//	[run]
+//	fmt = ["summary", "ndjson"]
+func parseFlags(flags *flag.FlagSet, args []string) {
+	flags.String("config", defaultConfigPath(), "TOML file of default flag values, set at the top level or in a table per subcommand, e.g. [run]. Empty to not use one")
+	path, explicit := configFlag(args)
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	if path != "" {
+		if err := applyConfig(flags, path); err != nil && (explicit || !os.IsNotExist(err)) {
+			log.Fatalln(err)
+		}
+	}
+	flags.Parse(args)
+}
+
+// configFlag finds the value of -config in args, before they're parsed, and
+// whether it was given at all. The value may be empty, to not use a config
+// file.
+func configFlag(args []string) (string, bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1], true
+		}
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config="), true
+		}
+	}
+	return "", false
+}
+
+// applyConfig sets the defaults of flags from the config file at path.
+func applyConfig(flags *flag.FlagSet, path string) error {
+	tables, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	applied := make(map[string]bool)
+	set := func(key, value string, required bool) error {
+		f := flags.Lookup(key)
+		if f == nil || key == "config" {
+			if required {
+				return fmt.Errorf("%s: [%s]: unknown flag %q", path, flags.Name(), key)
+			}
+			return nil
+		}
+		if err := f.Value.Set(value); err != nil {
+			return fmt.Errorf("%s: invalid value %q for %s: %s", path, value, key, err)
+		}
+		// the flag still counts as unset, so that e.g. -freq on the
+		// command line takes precedence over an interval in the file
+		f.DefValue = value
+		applied[key] = true
+		return nil
+	}
+	for key, value := range tables[""] {
+		if err := set(key, value, false); err != nil {
+			return err
+		}
+	}
+	for key, value := range tables[flags.Name()] {
+		if err := set(key, value, true); err != nil {
+			return err
+		}
+	}
+
+	// -freq is an alias for -interval, which is only used when given on the
+	// command line, so a freq in the file becomes the default interval
+	if applied["freq"] {
+		if applied["interval"] {
+			return fmt.Errorf("%s: only one of interval and freq may be set", path)
+		}
+		freq, _ := strconv.ParseFloat(flags.Lookup("freq").Value.String(), 64)
+		interval, err := samplingInterval(0, freq, false, true)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		return set("interval", interval.String(), false)
+	}
+	return nil
+}
+
+// readConfig reads the subset of TOML that config files need: tables, and
+// keys whose values are strings, numbers, booleans or one-line arrays of
+// them, which become comma-separated flag values. It returns the values as
+// flag strings, by table ("" being the top level).
+func readConfig(path string) (map[string]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tables := map[string]map[string]string{"": {}}
+	table := ""
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			if tables[table] == nil {
+				tables[table] = make(map[string]string)
+			}
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		key := strings.Trim(strings.TrimSpace(line[:i]), `"`)
+		value, err := parseConfigValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+		tables[table][key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// stripComment removes a # comment from line, unless it's inside a string.
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseConfigValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "["):
+		if !strings.HasSuffix(value, "]") {
+			return "", fmt.Errorf("arrays must be on one line")
+		}
+		var items []string
+		for _, item := range splitArray(value[1 : len(value)-1]) {
+			parsed, err := parseConfigValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, parsed)
+		}
+		return strings.Join(items, ","), nil
+	case strings.HasPrefix(value, `"`):
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", value)
+		}
+		return s, nil
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("invalid string %s", value)
+		}
+		return value[1 : len(value)-1], nil
+	case value == "true" || value == "false":
+		return value, nil
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(value, "_", ""), 64); err != nil {
+		return "", fmt.Errorf("unsupported value %s", value)
+	}
+	return strings.ReplaceAll(value, "_", ""), nil
+}
+
+// splitArray splits the items of a one-line array on the commas outside
+// strings.
+func splitArray(s string) []string {
+	var items []string
+	var quote rune
+	escaped := false
+	start := 0
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		items = append(items, last)
+	}
+	return items
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// containerInfo identifies the container a process runs in, as derived from
+// its cgroup path.
+type containerInfo struct {
+	Runtime string `json:"runtime"`
+	ID      string `json:"id"`
+	// Name is only known for Docker containers, when the Docker daemon is
+	// reachable
+	Name string `json:"name,omitempty"`
+	// Pod is the UID of the Kubernetes pod the container belongs to, if any
+	Pod string `json:"pod,omitempty"`
+}
+
+func (c *containerInfo) String() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	id := c.ID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	if c.Pod != "" {
+		return fmt.Sprintf("%s:%s (pod %s)", c.Runtime, id, c.Pod)
+	}
+	return c.Runtime + ":" + id
+}
+
+var (
+	containerIDPattern = regexp.MustCompile(`(docker|cri-containerd|containerd|crio|libpod)[-/]([0-9a-f]{64})`)
+	podUIDPattern      = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+)
+
+// parseContainer recognizes the cgroup paths used by Docker, containerd,
+// CRI-O and Podman, in both the cgroupfs and systemd layouts, e.g.
+// /docker/<id> or /kubepods.slice/.../cri-containerd-<id>.scope.
+func parseContainer(cgroupPath string) *containerInfo {
+	match := containerIDPattern.FindStringSubmatch(cgroupPath)
+	if match == nil {
+		return nil
+	}
+	c := &containerInfo{Runtime: match[1], ID: match[2]}
+	if c.Runtime == "cri-containerd" {
+		c.Runtime = "containerd"
+	}
+	if pod := podUIDPattern.FindStringSubmatch(cgroupPath); pod != nil {
+		c.Pod = strings.ReplaceAll(pod[1], "_", "-")
+	}
+	return c
+}
+
+// annotateContainers records the cgroup and container of each proc in s,
+// reusing what was found in lastSample for processes that were already
+// running.
+func annotateContainers(s, lastSample sample, names *containerNames) {
+	for pid, p := range s.Procs {
+		if prev, ok := lastSample.Procs[pid]; ok && prev.Command == p.Command {
+			p.Cgroup, p.Container = prev.Cgroup, prev.Container
+		} else if path, err := readCgroupPath(pid); err == nil {
+			p.Cgroup = path
+			if p.Container = parseContainer(path); p.Container != nil && p.Container.Runtime == "docker" {
+				p.Container.Name = names.lookup(p.Container.ID)
+			}
+		}
+		s.Procs[pid] = p
+	}
+}
+
+// printContainers breaks the run down by container, with processes outside
+// of any container grouped under "host".
+func printContainers(w io.Writer, samples []sample, opts reportOptions) error {
+	type containerStats struct {
+		name     string
+		procs    int
+		samples  int
+		commands map[string]int
+	}
+	stats := make(map[string]*containerStats)
+	for _, l := range lifetimes(samples) {
+		name := "host"
+		if l.proc.Container != nil {
+			name = l.proc.Container.String()
+		}
+		st, ok := stats[name]
+		if !ok {
+			st = &containerStats{name: name, commands: make(map[string]int)}
+			stats[name] = st
+		}
+		st.procs++
+		st.samples += l.samples()
+		st.commands[normalizeCommand(l.proc.Command, opts.normalize)]++
+	}
+
+	sorted := make([]*containerStats, 0, len(stats))
+	for _, st := range stats {
+		sorted = append(sorted, st)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].samples != sorted[j].samples {
+			return sorted[i].samples > sorted[j].samples
+		}
+		return sorted[i].name < sorted[j].name
+	})
+
+	fmt.Fprintln(w, "procs\tsamples\tcontainer\tcommands")
+	for _, st := range sorted {
+		commands := make([]string, 0, len(st.commands))
+		for command, n := range st.commands {
+			commands = append(commands, fmt.Sprintf("%s×%d", command, n))
+		}
+		sort.Strings(commands)
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\n", st.procs, st.samples, st.name, strings.Join(commands, " "))
+	}
+	return nil
+}
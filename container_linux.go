@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const containersSupported = true
+
+// readCgroupPath returns the cgroup v2 path of pid, or failing that the
+// longest of its cgroup v1 paths, which is the most specific.
+func readCgroupPath(pid int) (string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+	path := ""
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		// lines are hierarchy-id:controllers:path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[2] != "/" {
+			return parts[2], nil
+		}
+		if len(parts[2]) > len(path) {
+			path = parts[2]
+		}
+	}
+	return path, nil
+}
+
+// containerNames resolves Docker container IDs to names using the Docker
+// daemon's API, caching the results. Lookups fail quietly if the daemon
+// isn't reachable.
+type containerNames struct {
+	client *http.Client
+	names  map[string]string
+}
+
+func newContainerNames() *containerNames {
+	return &containerNames{
+		client: &http.Client{
+			Timeout: time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", "/var/run/docker.sock")
+				},
+			},
+		},
+		names: make(map[string]string),
+	}
+}
+
+func (n *containerNames) lookup(id string) string {
+	if name, ok := n.names[id]; ok {
+		return name
+	}
+	name := ""
+	if resp, err := n.client.Get("http://docker/containers/" + id + "/json"); err == nil {
+		var container struct{ Name string }
+		if resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&container) == nil {
+			name = strings.TrimPrefix(container.Name, "/")
+		}
+		resp.Body.Close()
+	}
+	n.names[id] = name
+	return name
+}
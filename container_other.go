@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+const containersSupported = false
+
+func readCgroupPath(pid int) (string, error) {
+	return "", errors.New("container detection is only supported on Linux")
+}
+
+type containerNames struct{}
+
+func newContainerNames() *containerNames {
+	return &containerNames{}
+}
+
+func (*containerNames) lookup(id string) string {
+	return ""
+}
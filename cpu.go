@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// annotateCPUTime fills in the cumulative CPU time of every proc in s.
+// Processes whose times can't be read (because they exited) are left
+// without them.
+func annotateCPUTime(s sample) {
+	for pid, p := range s.Procs {
+		if cpu, err := readCPUTime(pid); err == nil {
+			p.CPUTime = &cpu
+			s.Procs[pid] = p
+		}
+	}
+}
+
+// processCPU is the CPU time used by a process lifetime.
+type processCPU struct {
+	lifetime lifetime
+	// cpu is the total user and system time used, and peak the highest
+	// utilization between two consecutive samples (1 being one core)
+	cpu  time.Duration
+	peak float64
+}
+
+// processCPUs measures the CPU time used by each process lifetime in samples
+// that had its CPU time sampled.
+func processCPUs(samples []sample) []processCPU {
+	var cpus []processCPU
+	for _, l := range lifetimes(samples) {
+		pc := processCPU{lifetime: l}
+		sampled := false
+		var prev time.Duration
+		var prevAt time.Time
+		for i := l.first; i <= l.last && l.samples() > 0; i++ {
+			cpu := samples[i].Procs[l.proc.Pid].CPUTime
+			if cpu == nil {
+				continue
+			}
+			if sampled {
+				if elapsed := samples[i].At.Sub(prevAt); elapsed > 0 {
+					if u := float64(*cpu-prev) / float64(elapsed); u > pc.peak {
+						pc.peak = u
+					}
+				}
+			}
+			// the times are cumulative, so the latest is the total
+			sampled, prev, prevAt = true, *cpu, samples[i].At
+			pc.cpu = *cpu
+		}
+		if sampled {
+			cpus = append(cpus, pc)
+		}
+	}
+	return cpus
+}
+
+// printCPUUsage ranks commands by the CPU time their processes used, then
+// lists each process with its CPU time against how long it ran, telling the
+// processes that were busy apart from those that were mostly waiting.
+func printCPUUsage(w io.Writer, samples []sample, opts reportOptions) error {
+	cpus := processCPUs(samples)
+	if len(cpus) == 0 {
+		fmt.Fprintln(w, "no CPU times were sampled (use -cpu, on Linux)")
+		return nil
+	}
+
+	type commandCPU struct {
+		command   string
+		processes int
+		cpu, wall time.Duration
+	}
+	commands := make(map[string]*commandCPU)
+	for _, pc := range cpus {
+		key := normalizeCommand(pc.lifetime.proc.Command, opts.normalize)
+		c, ok := commands[key]
+		if !ok {
+			c = &commandCPU{command: key}
+			commands[key] = c
+		}
+		c.processes++
+		c.cpu += pc.cpu
+		c.wall += pc.lifetime.duration()
+	}
+	sorted := make([]*commandCPU, 0, len(commands))
+	for _, c := range commands {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].cpu != sorted[j].cpu {
+			return sorted[i].cpu > sorted[j].cpu
+		}
+		return sorted[i].command < sorted[j].command
+	})
+	sort.SliceStable(cpus, func(i, j int) bool {
+		return cpus[i].cpu > cpus[j].cpu
+	})
+
+	ms := time.Millisecond
+	utilization := func(cpu, wall time.Duration) float64 {
+		if wall <= 0 {
+			return 0
+		}
+		return float64(cpu) / float64(wall)
+	}
+	fmt.Fprintln(w, "cpu_seconds\twall_seconds\tutilization\tprocesses\tcommand")
+	for _, c := range sorted {
+		fmt.Fprintf(w, "%.3f\t%.3f\t%.2f\t%d\t%s\n",
+			c.cpu.Seconds(), c.wall.Seconds(), utilization(c.cpu, c.wall), c.processes, c.command)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "cpu\twall\tutilization\tpeak_utilization\tpid\tcommand")
+	for _, pc := range cpus {
+		wall := pc.lifetime.duration()
+		fmt.Fprintf(w, "%s\t%s\t%.2f\t%.2f\t%d\t%s\n",
+			pc.cpu.Round(ms), wall.Round(ms), utilization(pc.cpu, wall), pc.peak, pc.lifetime.proc.Pid, pc.lifetime.proc.Command)
+	}
+	return nil
+}
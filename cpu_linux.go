@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const cpuTimeSupported = true
+
+// clockTick is the unit of the CPU times in /proc, USER_HZ, which is 100 on
+// every Linux architecture.
+const clockTick = time.Second / 100
+
+// readCPUTime reads the user and system CPU time that pid has used from
+// /proc/<pid>/stat.
+func readCPUTime(pid int) (time.Duration, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// the command name is in parentheses and may contain anything, so
+	// count fields from the last ) instead
+	i := strings.LastIndexByte(string(stat), ')')
+	if i < 0 {
+		return 0, fmt.Errorf("could not parse /proc/%d/stat", pid)
+	}
+	// fields after the name start at 3 (state), so utime (14) and stime
+	// (15) are at 11 and 12
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("could not parse /proc/%d/stat", pid)
+	}
+	utime, err := strconv.ParseInt(fields[11], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	stime, err := strconv.ParseInt(fields[12], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(utime+stime) * clockTick, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+const cpuTimeSupported = false
+
+func readCPUTime(pid int) (time.Duration, error) {
+	return 0, errors.New("CPU times are only supported on Linux")
+}
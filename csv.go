@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+func printLifetimesAsCSV(w io.Writer, samples []sample, opts reportOptions) error {
+	return writeLifetimes(csv.NewWriter(w), samples, opts)
+}
+
+func printLifetimesAsTSV(w io.Writer, samples []sample, opts reportOptions) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = '\t'
+	return writeLifetimes(cw, samples, opts)
+}
+
+// writeLifetimes writes one row per process lifetime, with a column for each
+// captured environment variable and extra field. Extra fields are as of the
+// first sample of the lifetime.
+func writeLifetimes(cw *csv.Writer, samples []sample, opts reportOptions) error {
+	header := []string{"pid", "ppid", "pgid", "user", "command", "first_sample", "last_sample", "samples", "wall_seconds"}
+	for _, key := range opts.envKeys {
+		header = append(header, "env."+key)
+	}
+	for _, col := range opts.columns {
+		header = append(header, col)
+	}
+	cw.Write(header)
+	for _, l := range lifetimes(samples) {
+		row := []string{
+			strconv.Itoa(l.proc.Pid),
+			strconv.Itoa(l.proc.Ppid),
+			strconv.Itoa(l.proc.Pgid),
+			l.proc.User,
+			l.proc.Command,
+			strconv.Itoa(l.first),
+			strconv.Itoa(l.last),
+			strconv.Itoa(l.samples()),
+			strconv.FormatFloat(l.duration().Seconds(), 'f', 6, 64),
+		}
+		for _, key := range opts.envKeys {
+			row = append(row, l.proc.Env[key])
+		}
+		for _, col := range opts.columns {
+			row = append(row, l.proc.Extra[col])
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
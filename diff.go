@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// runDiff implements `pstree_prof diff a.ndjson b.ndjson`, comparing two runs
+// recorded with -fmt ndjson.
+func runDiff(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s diff [flags] run_a.ndjson run_b.ndjson\n", NAME)
+		flags.PrintDefaults()
+	}
+	normalizeExpr := flags.String("normalize", "", "Regexp used to extract the key that processes are matched by (default: base name of the executable)")
+	parseFlags(flags, args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		log.Fatalln("expected exactly two recorded runs")
+	}
+	normalize, err := compileOptionalRegexp(*normalizeExpr)
+	if err != nil {
+		log.Fatalf("invalid -normalize: %s\n", err)
+	}
+
+	a, err := readSamples(flags.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	b, err := readSamples(flags.Arg(1))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	printGroupDiff(os.Stdout, commandGroups(a, normalize), commandGroups(b, normalize))
+}
+
+// printGroupDiff lists every command that appears in either run, with how its
+// invocation count, samples and wall time changed from a to b. Commands are
+// ordered by the size of the change in wall time.
+func printGroupDiff(w io.Writer, a, b []*commandGroup) {
+	type change struct {
+		command string
+		a, b    *commandGroup
+	}
+	empty := &commandGroup{}
+	changes := make(map[string]*change)
+	for _, g := range a {
+		changes[g.command] = &change{command: g.command, a: g, b: empty}
+	}
+	for _, g := range b {
+		if c, ok := changes[g.command]; ok {
+			c.b = g
+		} else {
+			changes[g.command] = &change{command: g.command, a: empty, b: g}
+		}
+	}
+
+	sorted := make([]*change, 0, len(changes))
+	for _, c := range changes {
+		sorted = append(sorted, c)
+	}
+	abs := func(d time.Duration) time.Duration {
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		di, dj := abs(sorted[i].b.wall-sorted[i].a.wall), abs(sorted[j].b.wall-sorted[j].a.wall)
+		if di != dj {
+			return di > dj
+		}
+		return sorted[i].command < sorted[j].command
+	})
+
+	fmt.Fprintln(w, "status\tpids_a\tpids_b\tsamples_a\tsamples_b\twall_a\twall_b\twall_delta\tcommand")
+	ms := time.Millisecond
+	for _, c := range sorted {
+		status := "changed"
+		switch {
+		case c.a == empty:
+			status = "appeared"
+		case c.b == empty:
+			status = "disappeared"
+		case c.a.samples == c.b.samples && len(c.a.pids) == len(c.b.pids):
+			status = "same"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%s\t%+dms\t%s\n",
+			status,
+			len(c.a.pids), len(c.b.pids),
+			c.a.samples, c.b.samples,
+			c.a.wall.Round(ms), c.b.wall.Round(ms), (c.b.wall - c.a.wall).Milliseconds(),
+			c.command,
+		)
+	}
+}
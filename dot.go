@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// printProcTreeAsDot writes the union of every process observed during the
+// run as a Graphviz digraph, with an edge from each parent to its children.
+// Render it with e.g. `dot -Tsvg`.
+func printProcTreeAsDot(w io.Writer, samples []sample, opts reportOptions) error {
+	ls := lifetimes(samples)
+	parents := parentLifetimes(ls)
+
+	fmt.Fprintln(w, "digraph pstree {")
+	fmt.Fprintln(w, "\tnode [shape=box, fontname=monospace];")
+	for i, l := range ls {
+		fmt.Fprintf(w, "\tp%d [label=\"%s\\npid %d, %d samples\"];\n", i, dotEscaper.Replace(l.proc.Command), l.proc.Pid, l.samples())
+	}
+	for i, parent := range parents {
+		if parent >= 0 {
+			fmt.Fprintf(w, "\tp%d -> p%d;\n", parent, i)
+		}
+	}
+	fmt.Fprintln(w, "}")
+	return nil
+}
+
+// dotEscaper escapes text for use inside a double-quoted DOT label, where a
+// backslash introduces an escape sequence.
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
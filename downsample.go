@@ -0,0 +1,80 @@
+package main
+
+// downsampler bounds the number of samples kept during a run. Whenever the
+// limit is reached, adjacent pairs of samples are merged, and from then on
+// twice as many new samples are merged into each kept one, halving the
+// effective sampling rate. Merging rather than dropping samples means that
+// short-lived processes are still seen.
+type downsampler struct {
+	max int
+	// stride is the number of raw samples merged into each kept sample
+	stride int
+	// pending is the number of raw samples merged into the last kept sample
+	pending int
+}
+
+func newDownsampler(max int) *downsampler {
+	return &downsampler{max: max, stride: 1}
+}
+
+// add appends s to samples, merging as necessary to stay within the limit.
+func (d *downsampler) add(samples []sample, s sample) []sample {
+	if d.max <= 0 {
+		return append(samples, s)
+	}
+	if d.pending > 0 && d.pending < d.stride {
+		samples[len(samples)-1] = mergeSamples(samples[len(samples)-1], s)
+		d.pending++
+		return samples
+	}
+	if len(samples) >= d.max {
+		halved := samples[:0]
+		for i := 0; i < len(samples); i += 2 {
+			if i+1 < len(samples) {
+				halved = append(halved, mergeSamples(samples[i], samples[i+1]))
+			} else {
+				halved = append(halved, samples[i])
+			}
+		}
+		if len(samples)%2 == 1 {
+			// the last kept sample wasn't paired, so is only half full
+			d.pending = d.stride
+		} else {
+			d.pending = 2 * d.stride
+		}
+		d.stride *= 2
+		samples = halved
+		if d.pending < d.stride {
+			samples[len(samples)-1] = mergeSamples(samples[len(samples)-1], s)
+			d.pending++
+			return samples
+		}
+	}
+	d.pending = 1
+	return append(samples, s)
+}
+
+// mergeSamples combines two consecutive samples into one taken at the time of
+// the first, containing every process seen in either.
+func mergeSamples(a, b sample) sample {
+	merged := sample{
+		At:     a.At,
+		Procs:  make(map[int]proc, len(b.Procs)),
+		Cgroup: b.Cgroup,
+		Events: append(append([]procEvent(nil), a.Events...), b.Events...),
+		Output: append(append([]outputLine(nil), a.Output...), b.Output...),
+		Merged: a.weight() + b.weight(),
+		// b can only have timed out if a did too
+		TimedOut: b.TimedOut,
+	}
+	for pid, p := range a.Procs {
+		merged.Procs[pid] = p
+	}
+	for pid, p := range b.Procs {
+		merged.Procs[pid] = p
+	}
+	if merged.Cgroup == nil {
+		merged.Cgroup = a.Cgroup
+	}
+	return merged
+}
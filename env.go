@@ -0,0 +1,39 @@
+package main
+
+import "strings"
+
+// annotateEnv records the environment variables named by keys for each proc
+// in s. Environments are only read for processes that weren't in lastSample,
+// since changes made by a process to its own environment after it starts
+// aren't visible anyway.
+func annotateEnv(s, lastSample sample, keys []string) {
+	for pid, p := range s.Procs {
+		if prev, ok := lastSample.Procs[pid]; ok && prev.Command == p.Command {
+			p.Env = prev.Env
+		} else if environ, err := readEnviron(pid); err == nil {
+			p.Env = selectEnv(environ, keys)
+		}
+		s.Procs[pid] = p
+	}
+}
+
+// selectEnv picks the entries named by keys out of a list of KEY=value
+// strings.
+func selectEnv(environ []string, keys []string) map[string]string {
+	var env map[string]string
+	for _, kv := range environ {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			continue
+		}
+		for _, key := range keys {
+			if kv[:i] == key {
+				if env == nil {
+					env = make(map[string]string, len(keys))
+				}
+				env[key] = kv[i+1:]
+			}
+		}
+	}
+	return env
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+const envCaptureSupported = true
+
+func readEnviron(pid int) ([]string, error) {
+	buf, err := unix.SysctlRaw("kern.procargs2", pid)
+	if err != nil {
+		return nil, err
+	}
+	_, env, ok := parseProcArgs(buf)
+	if !ok {
+		return nil, errors.New("malformed KERN_PROCARGS2 buffer")
+	}
+	return env, nil
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const envCaptureSupported = true
+
+func readEnviron(pid int) ([]string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/environ", pid))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(string(data), "\x00"), "\x00"), nil
+}
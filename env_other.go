@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import "errors"
+
+const envCaptureSupported = false
+
+func readEnviron(pid int) ([]string, error) {
+	return nil, errors.New("environment capture is only supported on Linux and macOS")
+}
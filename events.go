@@ -0,0 +1,125 @@
+package main
+
+import "time"
+
+// procEvent is a fork, exec or exit reported by an event backend (-events)
+// between two samples.
+type procEvent struct {
+	At   time.Time `json:"at"`
+	Kind string    `json:"kind"` // "fork", "exec" or "exit"
+	Pid  int       `json:"pid"`
+	// Ppid is only set for forks
+	Ppid int `json:"ppid,omitempty"`
+	// Command is only set for execs, and is empty if the process exited
+	// before its command line could be read
+	Command string `json:"command,omitempty"`
+}
+
+// eventFilter keeps only the events of processes descending from those that
+// are being sampled.
+type eventFilter struct {
+	tracked map[int]bool
+}
+
+func newEventFilter(pid int) *eventFilter {
+	return &eventFilter{tracked: map[int]bool{pid: true}}
+}
+
+func (f *eventFilter) keep(events []procEvent) []procEvent {
+	var kept []procEvent
+	for _, e := range events {
+		switch e.Kind {
+		case "fork":
+			if !f.tracked[e.Ppid] {
+				continue
+			}
+			f.tracked[e.Pid] = true
+		case "exit":
+			if !f.tracked[e.Pid] {
+				continue
+			}
+			delete(f.tracked, e.Pid)
+		default:
+			if !f.tracked[e.Pid] {
+				continue
+			}
+		}
+		kept = append(kept, e)
+	}
+	return kept
+}
+
+// update adds the processes of s to those being tracked.
+func (f *eventFilter) update(s sample) {
+	for pid := range s.Procs {
+		f.tracked[pid] = true
+	}
+}
+
+// eventWindow is the result of replaying the events that happened between
+// two samples.
+type eventWindow struct {
+	// ended holds when processes that were running at the start of the
+	// window exited or exec'd a new command
+	ended map[int]time.Time
+	// opened holds the processes that started (or exec'd) during the window
+	// and were still running at its end
+	opened map[int]*lifetime
+	// finished holds the processes that started and ended within the window,
+	// i.e. that no sample could have seen
+	finished []lifetime
+}
+
+// replayEvents reconstructs the lifetimes implied by events, given the
+// processes in the sample before them.
+func replayEvents(events []procEvent, before map[int]proc) eventWindow {
+	w := eventWindow{ended: make(map[int]time.Time), opened: make(map[int]*lifetime)}
+	current := func(pid int) (proc, bool) {
+		if o, ok := w.opened[pid]; ok {
+			return o.proc, true
+		}
+		p, ok := before[pid]
+		return p, ok
+	}
+	end := func(pid int, at time.Time) {
+		if o, ok := w.opened[pid]; ok {
+			o.end = at
+			w.finished = append(w.finished, *o)
+			delete(w.opened, pid)
+		} else if _, ok := w.ended[pid]; !ok {
+			w.ended[pid] = at
+		}
+	}
+
+	for _, e := range events {
+		switch e.Kind {
+		case "fork":
+			// a forked child runs its parent's command until it execs
+			p, _ := current(e.Ppid)
+			p.Pid, p.Ppid, p.Children = e.Pid, e.Ppid, nil
+			w.opened[e.Pid] = &lifetime{proc: p, start: e.At}
+		case "exec":
+			p, ok := current(e.Pid)
+			if !ok {
+				p = proc{Pid: e.Pid}
+			}
+			command := e.Command
+			if command == "" {
+				command = "(unknown)"
+			}
+			if o, ok := w.opened[e.Pid]; ok {
+				// the copy of the parent that ran between fork and exec
+				// isn't interesting, so the new command replaces it
+				o.proc.Command = command
+				o.start = e.At
+				break
+			}
+			end(e.Pid, e.At)
+			p.Command = command
+			w.opened[e.Pid] = &lifetime{proc: p, start: e.At}
+		case "exit":
+			end(e.Pid, e.At)
+		}
+	}
+	return w
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const procEventsSupported = true
+
+// constants from linux/connector.h and linux/cn_proc.h
+const (
+	cnIdxProc          = 0x1
+	cnValProc          = 0x1
+	procCnMcastListen  = 1
+	procEventFork      = 0x00000001
+	procEventExec      = 0x00000002
+	procEventExit      = 0x80000000
+	cnMsgHeaderLen     = 20 // struct cn_msg without data
+	procEventHeaderLen = 16 // what, cpu and timestamp_ns of struct proc_event
+)
+
+// eventsPollInterval bounds how long the receiver blocks on the socket, and
+// so how long closing the source takes.
+const eventsPollInterval = 100 * time.Millisecond
+
+// netlink messages are in host byte order
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// procEventSource receives fork, exec and exit events for every process on
+// the machine from the kernel's process events connector, which requires
+// CAP_NET_ADMIN (i.e. running as root).
+type procEventSource struct {
+	fd int
+	// bootTime converts the kernel's monotonic event timestamps to wall time
+	bootTime time.Time
+	// done asks the receiver to stop, which closes stopped once it has, so
+	// that the fd isn't closed (and reused) while it is still reading
+	done, stopped chan struct{}
+
+	mu     sync.Mutex
+	events []procEvent
+	err    error
+}
+
+func startProcEvents() (*procEventSource, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM, unix.NETLINK_CONNECTOR)
+	if err != nil {
+		return nil, fmt.Errorf("could not open process events connector: %s", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: cnIdxProc, Pid: uint32(os.Getpid())}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("could not bind process events connector: %s", err)
+	}
+	// the receive buffer has to absorb bursts of events from the whole
+	// machine between reads
+	unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, 4<<20)
+	// netlink sockets can't be shut down, so the receiver wakes up
+	// periodically to see whether it should stop instead
+	timeout := unix.NsecToTimeval(eventsPollInterval.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("could not set a timeout on the process events connector: %s", err)
+	}
+
+	// nlmsghdr, then cn_msg, then the PROC_CN_MCAST_LISTEN op
+	msg := make([]byte, unix.NLMSG_HDRLEN+cnMsgHeaderLen+4)
+	nativeEndian.PutUint32(msg[0:], uint32(len(msg)))
+	nativeEndian.PutUint16(msg[4:], unix.NLMSG_DONE)
+	nativeEndian.PutUint32(msg[12:], uint32(os.Getpid()))
+	cn := msg[unix.NLMSG_HDRLEN:]
+	nativeEndian.PutUint32(cn[0:], cnIdxProc)
+	nativeEndian.PutUint32(cn[4:], cnValProc)
+	nativeEndian.PutUint16(cn[16:], 4)
+	nativeEndian.PutUint32(cn[cnMsgHeaderLen:], procCnMcastListen)
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("could not subscribe to process events: %s", err)
+	}
+
+	var mono unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &mono); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	s := &procEventSource{
+		fd:       fd,
+		bootTime: time.Now().Add(-time.Duration(mono.Nano())),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go s.receive()
+	return s, nil
+}
+
+func (s *procEventSource) receive() {
+	defer close(s.stopped)
+	buf := make([]byte, os.Getpagesize())
+	for {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		n, _, err := unix.Recvfrom(s.fd, buf, 0)
+		if err == unix.EINTR || err == unix.EAGAIN {
+			continue
+		}
+		if err == unix.ENOBUFS {
+			s.setErr(fmt.Errorf("process events were dropped because they arrived too quickly"))
+			continue
+		}
+		if err != nil {
+			s.setErr(err)
+			return
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, m := range msgs {
+			if e, ok := s.parseEvent(m.Data); ok {
+				s.mu.Lock()
+				s.events = append(s.events, e)
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (s *procEventSource) parseEvent(data []byte) (procEvent, bool) {
+	if len(data) < cnMsgHeaderLen+procEventHeaderLen {
+		return procEvent{}, false
+	}
+	ev := data[cnMsgHeaderLen:]
+	what := nativeEndian.Uint32(ev[0:])
+	at := s.bootTime.Add(time.Duration(nativeEndian.Uint64(ev[8:])))
+	body := ev[procEventHeaderLen:]
+
+	switch what {
+	case procEventFork:
+		if len(body) < 16 {
+			return procEvent{}, false
+		}
+		// parent_pid, parent_tgid, child_pid, child_tgid
+		childPid, childTgid := nativeEndian.Uint32(body[8:]), nativeEndian.Uint32(body[12:])
+		if childPid != childTgid {
+			// a new thread, not a new process
+			return procEvent{}, false
+		}
+		return procEvent{At: at, Kind: "fork", Pid: int(childTgid), Ppid: int(nativeEndian.Uint32(body[4:]))}, true
+	case procEventExec:
+		if len(body) < 8 {
+			return procEvent{}, false
+		}
+		pid := int(nativeEndian.Uint32(body[4:]))
+		return procEvent{At: at, Kind: "exec", Pid: pid, Command: readCmdline(pid)}, true
+	case procEventExit:
+		if len(body) < 8 {
+			return procEvent{}, false
+		}
+		pid, tgid := nativeEndian.Uint32(body[0:]), nativeEndian.Uint32(body[4:])
+		if pid != tgid {
+			return procEvent{}, false
+		}
+		return procEvent{At: at, Kind: "exit", Pid: int(tgid)}, true
+	}
+	return procEvent{}, false
+}
+
+func (s *procEventSource) setErr(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+}
+
+// drain returns the events that happened before cutoff, in order.
+func (s *procEventSource) drain(cutoff time.Time) ([]procEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		err := s.err
+		s.err = nil
+		return nil, err
+	}
+	// events from different CPUs can arrive slightly out of order, so don't
+	// stop at the first one after the cutoff
+	var drained []procEvent
+	remaining := s.events[:0]
+	for _, e := range s.events {
+		if e.At.Before(cutoff) {
+			drained = append(drained, e)
+		} else {
+			remaining = append(remaining, e)
+		}
+	}
+	s.events = remaining
+	return drained, nil
+}
+
+// Close stops receiving events, waiting for the receiver to finish before
+// closing the socket.
+func (s *procEventSource) Close() error {
+	close(s.done)
+	<-s.stopped
+	return unix.Close(s.fd)
+}
+
+// readCmdline reads the command line of a process that has just exec'd. It
+// is racy by nature: very short-lived processes may already be gone.
+func readCmdline(pid int) string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil || len(data) == 0 {
+		return ""
+	}
+	return strings.Join(strings.Split(string(bytes.TrimRight(data, "\x00")), "\x00"), " ")
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+const procEventsSupported = false
+
+type procEventSource struct{}
+
+func startProcEvents() (*procEventSource, error) {
+	return nil, errors.New("process events are only supported on Linux")
+}
+
+func (*procEventSource) drain(cutoff time.Time) ([]procEvent, error) {
+	return nil, nil
+}
+
+func (*procEventSource) Close() error {
+	return nil
+}
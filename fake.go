@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// fakeLister is a deterministic procLister that returns a scripted sequence
+// of process tables, one per call, and then no processes at all. It stands
+// in for the machine's processes when exercising the sampling and report
+// code, and is the fixture backend, which replays a run recorded with -fmt
+// ndjson.
+//
+// Since the pids it lists aren't real, it scopes the command to the roots of
+// each table rather than to the pid of the command actually started.
+type fakeLister struct {
+	tables []map[int]proc
+	next   int
+	// latest is the table most recently listed
+	latest map[int]proc
+}
+
+func newFakeLister(tables ...map[int]proc) *fakeLister {
+	return &fakeLister{tables: tables}
+}
+
+// newFixtureLister replays the samples recorded at path.
+func newFixtureLister(path string) (*fakeLister, error) {
+	samples, err := readSamples(path)
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]map[int]proc, len(samples))
+	for i, s := range samples {
+		tables[i] = s.Procs
+	}
+	return newFakeLister(tables...), nil
+}
+
+func (l *fakeLister) listProcs() (map[int]proc, error) {
+	procs := make(map[int]proc)
+	if l.next < len(l.tables) {
+		for pid, p := range l.tables[l.next] {
+			// as with any other lister, children are filled in by the
+			// sampler
+			p.Children = nil
+			procs[pid] = p
+		}
+		l.next++
+	}
+	l.latest = procs
+	return procs, nil
+}
+
+func (l *fakeLister) scope(cmd *exec.Cmd) error {
+	return nil
+}
+
+// scopedPids returns the processes in the latest table whose parents aren't
+// in it.
+func (l *fakeLister) scopedPids() ([]int, error) {
+	var roots []int
+	for pid, p := range l.latest {
+		if _, ok := l.latest[p.Ppid]; !ok || p.Ppid == pid {
+			roots = append(roots, pid)
+		}
+	}
+	sort.Ints(roots)
+	return roots, nil
+}
+
+// fixtureBackend is the prefix of -backend values naming a recording to
+// replay, e.g. fixture:run.ndjson.
+const fixtureBackend = "fixture:"
+
+// checkFakeFlags rejects the flags that would act on the pids a fakeLister
+// lists as if they were real processes.
+func checkFakeFlags(flags map[string]bool) error {
+	var set []string
+	for name, isSet := range flags {
+		if isSet {
+			set = append(set, "-"+name)
+		}
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	sort.Strings(set)
+	return fmt.Errorf("the fixture backend can't be combined with %s, since its processes aren't running", strings.Join(set, ", "))
+}
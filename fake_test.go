@@ -0,0 +1,147 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+var sampleStart = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// buildTables is a scripted run of `make`, sampled every 10ms: it runs a
+// compiler through `sh -c`, another directly, and then `sudo true`, which
+// belongs to another user.
+func buildTables() []map[int]proc {
+	var (
+		makeProc = proc{User: "chris", Pid: 100, Ppid: 1, Pgid: 100, Command: "make"}
+		sh       = proc{User: "chris", Pid: 101, Ppid: 100, Pgid: 100, Command: "sh -c cc a.c"}
+		ccA      = proc{User: "chris", Pid: 102, Ppid: 101, Pgid: 100, Command: "cc a.c"}
+		ccB      = proc{User: "chris", Pid: 103, Ppid: 100, Pgid: 100, Command: "cc b.c"}
+		sudo     = proc{User: "root", Pid: 104, Ppid: 100, Pgid: 100, Command: "sudo true"}
+		trueProc = proc{User: "root", Pid: 105, Ppid: 104, Pgid: 100, Command: "true"}
+	)
+	table := func(procs ...proc) map[int]proc {
+		t := make(map[int]proc, len(procs))
+		for _, p := range procs {
+			t[p.Pid] = p
+		}
+		return t
+	}
+	return []map[int]proc{
+		table(makeProc),
+		table(makeProc, sh, ccA),
+		table(makeProc, sh, ccA, ccB),
+		table(makeProc, ccB, sudo, trueProc),
+		table(makeProc),
+	}
+}
+
+// recordSamples samples the command rooted at pid until lister runs out of
+// processes, as the sampling loop would, spacing the samples 10ms apart.
+func recordSamples(t *testing.T, lister procLister, pid int, user *userFilter) []sample {
+	t.Helper()
+	var samples []sample
+	var last sample
+	for {
+		s, err := sampleProcs(lister, pid, last, false, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s.Procs) == 0 {
+			return samples
+		}
+		s.At = sampleStart.Add(time.Duration(len(samples)) * 10 * time.Millisecond)
+		samples = append(samples, s)
+		last = s
+	}
+}
+
+func pids(procs map[int]proc) []int {
+	pids := make([]int, 0, len(procs))
+	for pid := range procs {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+func TestSampleProcs(t *testing.T) {
+	samples := recordSamples(t, newFakeLister(buildTables()...), 100, nil)
+	want := [][]int{
+		{100},
+		{100, 101, 102},
+		{100, 101, 102, 103},
+		{100, 103, 104, 105},
+		{100},
+	}
+	if len(samples) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(samples), len(want))
+	}
+	for i, s := range samples {
+		if got := pids(s.Procs); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("sample %d: got pids %v, want %v", i, got, want[i])
+		}
+	}
+
+	children := samples[2].Procs[100].Children
+	sort.Ints(children)
+	if !reflect.DeepEqual(children, []int{101, 103}) {
+		t.Errorf("got children %v of make, want [101 103]", children)
+	}
+	if got := samples[2].Procs[101].Children; !reflect.DeepEqual(got, []int{102}) {
+		t.Errorf("got children %v of sh, want [102]", got)
+	}
+}
+
+func TestSampleProcsUser(t *testing.T) {
+	user := &userFilter{name: "chris", uid: "1000"}
+	samples := recordSamples(t, newFakeLister(buildTables()...), 100, user)
+	// sudo and its child are left out, even though they're in the tree
+	if got := pids(samples[3].Procs); !reflect.DeepEqual(got, []int{100, 103}) {
+		t.Errorf("got pids %v, want [100 103]", got)
+	}
+}
+
+func TestLifetimes(t *testing.T) {
+	samples := recordSamples(t, newFakeLister(buildTables()...), 100, nil)
+	type want struct {
+		first, last, weight int
+		duration            time.Duration
+	}
+	wants := map[string]want{
+		"make":         {0, 4, 5, 40 * time.Millisecond},
+		"sh -c cc a.c": {1, 2, 2, 20 * time.Millisecond},
+		"cc a.c":       {1, 2, 2, 20 * time.Millisecond},
+		"cc b.c":       {2, 3, 2, 20 * time.Millisecond},
+		"sudo true":    {3, 3, 1, 10 * time.Millisecond},
+		"true":         {3, 3, 1, 10 * time.Millisecond},
+	}
+	ls := lifetimes(samples)
+	if len(ls) != len(wants) {
+		t.Fatalf("got %d lifetimes, want %d", len(ls), len(wants))
+	}
+	for _, l := range ls {
+		w, ok := wants[l.proc.Command]
+		if !ok {
+			t.Errorf("unexpected lifetime of %q", l.proc.Command)
+			continue
+		}
+		got := want{l.first, l.last, l.weight, l.duration()}
+		if got != w {
+			t.Errorf("%s: got %+v, want %+v", l.proc.Command, got, w)
+		}
+	}
+}
+
+func TestFakeListerScopedPids(t *testing.T) {
+	tables := buildTables()
+	// a process that was reparented to init is a root of its own
+	orphan := proc{User: "chris", Pid: 200, Ppid: 1, Pgid: 100, Command: "sleep 10"}
+	tables[4][orphan.Pid] = orphan
+
+	samples := recordSamples(t, newFakeLister(tables...), 100, nil)
+	if got := pids(samples[4].Procs); !reflect.DeepEqual(got, []int{100, 200}) {
+		t.Errorf("got pids %v, want [100 200]", got)
+	}
+}
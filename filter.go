@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"regexp"
+	"strings"
+)
+
+// filterSamples returns a copy of samples containing only the procs whose
+// command matches include (if set) and does not match exclude (if set).
+// Children lists are pruned so they only reference procs that were kept.
+func filterSamples(samples []sample, include, exclude *regexp.Regexp) []sample {
+	if include == nil && exclude == nil {
+		return samples
+	}
+
+	keep := func(p proc) bool {
+		if include != nil && !include.MatchString(p.Command) {
+			return false
+		}
+		if exclude != nil && exclude.MatchString(p.Command) {
+			return false
+		}
+		return true
+	}
+
+	filtered := make([]sample, len(samples))
+	for i, s := range samples {
+		procs := make(map[int]proc, len(s.Procs))
+		for pid, p := range s.Procs {
+			if keep(p) {
+				procs[pid] = p
+			}
+		}
+		for pid, p := range procs {
+			var children []int
+			for _, child := range p.Children {
+				if _, ok := procs[child]; ok {
+					children = append(children, child)
+				}
+			}
+			p.Children = children
+			procs[pid] = p
+		}
+		s.Procs = procs
+		filtered[i] = s
+	}
+	return filtered
+}
+
+// compileOptionalRegexp compiles expr, treating the empty string as "no regexp".
+func compileOptionalRegexp(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+// userFilter matches the processes owned by a user, as named by a backend:
+// usually by user name, but by uid where the name isn't known.
+type userFilter struct {
+	name, uid string
+}
+
+// newUserFilter looks up name, which may be a user name or uid, or "self" for
+// the user running pstree_prof.
+func newUserFilter(name string) (*userFilter, error) {
+	var u *user.User
+	var err error
+	switch {
+	case name == "self":
+		u, err = user.Current()
+	case strings.Trim(name, "0123456789") == "":
+		u, err = user.LookupId(name)
+	default:
+		u, err = user.Lookup(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid -user: %s", err)
+	}
+	return &userFilter{name: u.Username, uid: u.Uid}, nil
+}
+
+func (f *userFilter) matches(p proc) bool {
+	if p.User == f.name || p.User == f.uid {
+		return true
+	}
+	// procps truncates long user names to fit the column, marking them
+	// with a +
+	truncated := strings.TrimSuffix(p.User, "+")
+	return truncated != p.User && strings.HasPrefix(f.name, truncated)
+}
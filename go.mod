@@ -3,8 +3,12 @@ module github.com/christianscott/pstree_prof
 go 1.16
 
 require (
-	go.opentelemetry.io/otel v1.6.3 // indirect
-	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.6.3 // indirect
-	go.opentelemetry.io/otel/sdk v1.6.3 // indirect
-	go.opentelemetry.io/otel/trace v1.6.3 // indirect
+	github.com/google/pprof v0.0.0-20211214055906-6f57359322fd
+	go.opentelemetry.io/otel v1.6.3
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.6.3
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.6.3
+	go.opentelemetry.io/otel/sdk v1.6.3
+	go.opentelemetry.io/otel/trace v1.6.3
+	golang.org/x/sys v0.0.0-20211007075335-d3039528d8ac
+	google.golang.org/protobuf v1.28.0
 )
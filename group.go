@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// normalizeCommand reduces a command line to a key that invocations of the
+// same program share. If expr is nil the key is the base name of the
+// executable, e.g. `/usr/bin/python3 foo.py --x` becomes `python3`. Otherwise
+// the key is the first capture group of expr (or the whole match if it has no
+// groups), falling back to the full command when expr doesn't match.
+func normalizeCommand(command string, expr *regexp.Regexp) string {
+	if expr != nil {
+		match := expr.FindStringSubmatch(command)
+		switch {
+		case match == nil:
+			return command
+		case len(match) > 1:
+			return match[1]
+		default:
+			return match[0]
+		}
+	}
+
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return command
+	}
+	return filepath.Base(fields[0])
+}
+
+// commandGroup aggregates the processes sharing a normalized command.
+type commandGroup struct {
+	command string
+	samples int
+	pids    map[int]struct{}
+	wall    time.Duration
+}
+
+// commandGroups aggregates samples by normalized command, ordered by the
+// number of samples each group appeared in.
+func commandGroups(samples []sample, normalize *regexp.Regexp) []*commandGroup {
+	groups := make(map[string]*commandGroup)
+	for _, l := range lifetimes(samples) {
+		key := normalizeCommand(l.proc.Command, normalize)
+		g, ok := groups[key]
+		if !ok {
+			g = &commandGroup{command: key, pids: make(map[int]struct{})}
+			groups[key] = g
+		}
+		g.samples += l.samples()
+		g.pids[l.proc.Pid] = struct{}{}
+		g.wall += l.duration()
+	}
+
+	sorted := make([]*commandGroup, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].samples != sorted[j].samples {
+			return sorted[i].samples > sorted[j].samples
+		}
+		return sorted[i].command < sorted[j].command
+	})
+	return sorted
+}
+
+func printCommandGroups(w io.Writer, samples []sample, opts reportOptions) error {
+	fmt.Fprintln(w, "samples\tpids\twall\tcommand")
+	for _, g := range commandGroups(samples, opts.normalize) {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\n", g.samples, len(g.pids), g.wall.Round(time.Millisecond), g.command)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// startExecHook starts the -exec-hook command and returns a stream of samples
+// to its stdin. Closing the stream closes stdin and waits for the hook to
+// exit.
+func startExecHook(command []string) (*sampleStream, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start -exec-hook: %s", err)
+	}
+	return &sampleStream{closer: &hookCloser{stdin: stdin, cmd: cmd}, enc: json.NewEncoder(stdin)}, nil
+}
+
+type hookCloser struct {
+	stdin io.Closer
+	cmd   *exec.Cmd
+}
+
+func (h *hookCloser) Close() error {
+	h.stdin.Close()
+	if err := h.cmd.Wait(); err != nil {
+		return fmt.Errorf("-exec-hook failed: %s", err)
+	}
+	return nil
+}
+
+// runExecHook runs the -exec-hook command with the output of write on its
+// stdin, for -exec-hook-fmt.
+func runExecHook(command []string, write func(w io.Writer) error) error {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not start -exec-hook: %s", err)
+	}
+	writeErr := write(stdin)
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("-exec-hook failed: %s", err)
+	}
+	return writeErr
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ioCounters are cumulative over the lifetime of a process, except for FDs
+// which is the number open at the time of the sample.
+type ioCounters struct {
+	ReadBytes  int64 `json:"read_bytes"`
+	WriteBytes int64 `json:"write_bytes"`
+	FDs        int   `json:"fds"`
+}
+
+// annotateIOCounters fills in the I/O counters of every proc in s. Processes
+// whose counters can't be read (because they exited, or belong to another
+// user) are left without them.
+func annotateIOCounters(s sample) {
+	for pid, p := range s.Procs {
+		if counters, err := readIOCounters(pid); err == nil {
+			p.IO = counters
+			s.Procs[pid] = p
+		}
+	}
+}
+
+// printIOCounters reports the bytes read from and written to storage by each
+// command, summed over its processes, and the most file descriptors any one
+// of them had open.
+func printIOCounters(w io.Writer, samples []sample, opts reportOptions) error {
+	type ioStats struct {
+		command       string
+		read, written int64
+		peakFDs       int
+	}
+	stats := make(map[string]*ioStats)
+	for _, l := range lifetimes(samples) {
+		// the counters are cumulative, so the final sample of each lifetime
+		// holds its totals
+		var last *ioCounters
+		peakFDs := 0
+		for i := l.first; i <= l.last; i++ {
+			counters := samples[i].Procs[l.proc.Pid].IO
+			if counters == nil {
+				continue
+			}
+			last = counters
+			if counters.FDs > peakFDs {
+				peakFDs = counters.FDs
+			}
+		}
+		if last == nil {
+			continue
+		}
+
+		st, ok := stats[l.proc.Command]
+		if !ok {
+			st = &ioStats{command: l.proc.Command}
+			stats[l.proc.Command] = st
+		}
+		st.read += last.ReadBytes
+		st.written += last.WriteBytes
+		if peakFDs > st.peakFDs {
+			st.peakFDs = peakFDs
+		}
+	}
+
+	sorted := make([]*ioStats, 0, len(stats))
+	for _, st := range stats {
+		sorted = append(sorted, st)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i].read+sorted[i].written, sorted[j].read+sorted[j].written
+		if a != b {
+			return a > b
+		}
+		return sorted[i].command < sorted[j].command
+	})
+
+	fmt.Fprintln(w, "read_bytes\twrite_bytes\tpeak_fds\tcommand")
+	for _, st := range sorted {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%s\n", st.read, st.written, st.peakFDs, st.command)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const ioCountersSupported = true
+
+// readIOCounters reads the storage I/O counters and open file descriptor
+// count of pid from /proc. Counters of processes owned by other users are
+// only readable with CAP_SYS_PTRACE.
+func readIOCounters(pid int) (*ioCounters, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/io", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	counters := &ioCounters{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value := splitField(scanner.Text())
+		switch key {
+		case "read_bytes":
+			counters.ReadBytes, _ = strconv.ParseInt(value, 10, 64)
+		case "write_bytes":
+			counters.WriteBytes, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	fds, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+	if err != nil {
+		return nil, err
+	}
+	counters.FDs = len(fds)
+	return counters, nil
+}
+
+// splitField splits a "key: value" line as found in /proc/<pid>/io and
+// /proc/<pid>/status.
+func splitField(line string) (string, string) {
+	i := strings.IndexByte(line, ':')
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], strings.TrimSpace(line[i+1:])
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+const ioCountersSupported = false
+
+func readIOCounters(pid int) (*ioCounters, error) {
+	return nil, errors.New("I/O counters are only supported on Linux")
+}
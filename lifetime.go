@@ -0,0 +1,153 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// lifetime is an uninterrupted run of samples in which a process was observed.
+type lifetime struct {
+	proc proc
+	// first and last are the indices of the first and last samples that
+	// contained the process
+	first, last int
+	// weight is the number of samples the process was seen in, accounting
+	// for samples merged by -max-samples
+	weight int
+	// start is the time of the first sample containing the process, end is
+	// the time of the first sample without it (or of the final sample if the
+	// process was still running when sampling stopped)
+	start, end time.Time
+}
+
+func (l lifetime) samples() int {
+	return l.weight
+}
+
+func (l lifetime) duration() time.Duration {
+	return l.end.Sub(l.start)
+}
+
+// lifetimes reconstructs the lifetime of every process seen in samples,
+// ordered by when they were first seen. A pid that disappears and later
+// reappears, or that changes its command, is treated as a new process.
+//
+// If events were recorded, they are used to pin down when processes really
+// started and ended, and to add the processes that came and went between
+// samples (with a weight of 0, since no sample contains them).
+func lifetimes(samples []sample) []lifetime {
+	var done []lifetime
+	running := make(map[int]*lifetime)
+	for i, s := range samples {
+		var before map[int]proc
+		if i > 0 {
+			before = samples[i-1].Procs
+		}
+		window := replayEvents(s.Events, before)
+
+		for pid, l := range running {
+			if p, ok := s.Procs[pid]; !ok || p.Command != l.proc.Command {
+				l.end = s.At
+				if at, ok := window.ended[pid]; ok {
+					l.end = at
+				}
+				done = append(done, *l)
+				delete(running, pid)
+			}
+		}
+		for _, l := range window.finished {
+			l.first, l.last = i, i
+			done = append(done, l)
+		}
+		for pid, p := range s.Procs {
+			if l, ok := running[pid]; ok {
+				l.last = i
+				l.weight += s.weight()
+				continue
+			}
+			start := s.At
+			if o, ok := window.opened[pid]; ok && o.proc.Command == p.Command {
+				start = o.start
+			}
+			running[pid] = &lifetime{proc: p, first: i, last: i, weight: s.weight(), start: start}
+		}
+	}
+	for _, l := range running {
+		l.end = samples[len(samples)-1].At
+		done = append(done, *l)
+	}
+
+	sort.Slice(done, func(i, j int) bool {
+		if done[i].first != done[j].first {
+			return done[i].first < done[j].first
+		}
+		return done[i].proc.Pid < done[j].proc.Pid
+	})
+	return done
+}
+
+// parentLifetimes returns, for each of ls (as returned by lifetimes), the
+// index of the lifetime of its parent process, or -1 if the parent was never
+// observed while the process was running.
+func parentLifetimes(ls []lifetime) []int {
+	parents := make([]int, len(ls))
+	latest := make(map[int]int) // pid -> index of its most recent lifetime
+	for i, l := range ls {
+		parents[i] = -1
+		if j, ok := latest[l.proc.Ppid]; ok && ls[j].last >= l.first {
+			parents[i] = j
+		}
+		latest[l.proc.Pid] = i
+	}
+	return parents
+}
+
+// ancestry returns the chain of processes from pid up to the oldest ancestor
+// present in procs, starting with pid itself.
+func ancestry(procs map[int]proc, pid int) []proc {
+	var chain []proc
+	seen := make(map[int]bool)
+	for {
+		p, ok := procs[pid]
+		if !ok || seen[pid] {
+			return chain
+		}
+		seen[pid] = true
+		chain = append(chain, p)
+		pid = p.Ppid
+	}
+}
+
+// ancestryPath describes p by its chain of ancestors in procs, oldest first,
+// e.g. `make → sh → cc1`, naming each process by name(command).
+func ancestryPath(procs map[int]proc, p proc, name func(command string) string) string {
+	chain := []proc{p}
+	for _, ancestor := range ancestry(procs, p.Ppid) {
+		if ancestor.Pid == p.Pid {
+			break
+		}
+		chain = append(chain, ancestor)
+	}
+	names := make([]string, len(chain))
+	for i, ancestor := range chain {
+		names[len(chain)-1-i] = name(ancestor.Command)
+	}
+	return strings.Join(names, " → ")
+}
+
+// sampleDurations returns how much wall time each sample accounts for: the
+// time until the next sample, or for the final sample the same as the one
+// before it.
+func sampleDurations(samples []sample) []time.Duration {
+	durations := make([]time.Duration, len(samples))
+	for i := range samples {
+		switch {
+		case i+1 < len(samples):
+			durations[i] = samples[i+1].At.Sub(samples[i].At)
+		case i > 0:
+			durations[i] = durations[i-1]
+		}
+	}
+	return durations
+}
@@ -0,0 +1,160 @@
+//go:build darwin
+// +build darwin
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const defaultBackend = "sysctl"
+
+// SZOMB from sys/proc.h, the p_stat of a process that has exited but not been
+// reaped
+const szomb = 5
+
+var procListers = map[string]func() procLister{
+	"ps":     func() procLister { return psLister{} },
+	"sysctl": func() procLister { return newSysctlLister() },
+}
+
+// sysctlLister enumerates processes with sysctl(KERN_PROC_ALL), avoiding the
+// cost of forking `ps` for every sample. Command lines come from
+// KERN_PROCARGS2 and are cached, since a process's arguments only change when
+// it execs (which also resets its start time).
+type sysctlLister struct {
+	commands map[int]cachedCommand
+	users    map[uint32]string
+}
+
+type cachedCommand struct {
+	started unix.Timeval
+	comm    string
+	command string
+}
+
+func newSysctlLister() *sysctlLister {
+	return &sysctlLister{
+		commands: make(map[int]cachedCommand),
+		users:    make(map[uint32]string),
+	}
+}
+
+func (l *sysctlLister) listProcs() (map[int]proc, error) {
+	kprocs, err := unix.SysctlKinfoProcSlice("kern.proc.all")
+	if err != nil {
+		return nil, fmt.Errorf("could not list processes: %s", err)
+	}
+
+	procs := make(map[int]proc, len(kprocs))
+	for _, kp := range kprocs {
+		pid := int(kp.Proc.P_pid)
+		procs[pid] = proc{
+			User:    l.user(kp.Eproc.Ucred.Uid),
+			Pid:     pid,
+			Ppid:    int(kp.Eproc.Ppid),
+			Pgid:    int(kp.Eproc.Pgid),
+			Zombie:  kp.Proc.P_stat == szomb,
+			Command: l.command(pid, kp.Proc.P_starttime, commName(kp.Proc.P_comm)),
+		}
+	}
+
+	for pid := range l.commands {
+		if _, ok := procs[pid]; !ok {
+			delete(l.commands, pid)
+		}
+	}
+	return procs, nil
+}
+
+func (l *sysctlLister) command(pid int, started unix.Timeval, comm string) string {
+	if c, ok := l.commands[pid]; ok && c.started == started && c.comm == comm {
+		return c.command
+	}
+
+	// like ps, fall back to the parenthesized accounting name if the
+	// arguments aren't readable (e.g. the process belongs to another user)
+	command := "(" + comm + ")"
+	if buf, err := unix.SysctlRaw("kern.procargs2", pid); err == nil {
+		if args, _, ok := parseProcArgs(buf); ok {
+			command = strings.Join(args, " ")
+		}
+	}
+	l.commands[pid] = cachedCommand{started: started, comm: comm, command: command}
+	return command
+}
+
+func (l *sysctlLister) user(uid uint32) string {
+	if name, ok := l.users[uid]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	l.users[uid] = name
+	return name
+}
+
+// parseProcArgs splits a KERN_PROCARGS2 buffer into argv and the
+// environment. The buffer is laid out as argc, the executable path, NUL
+// padding, then argc NUL-terminated arguments followed by the NUL-terminated
+// environment entries.
+func parseProcArgs(buf []byte) (args, env []string, ok bool) {
+	if len(buf) < 4 {
+		return nil, nil, false
+	}
+	argc := int(binary.LittleEndian.Uint32(buf))
+	rest := buf[4:]
+
+	end := bytes.IndexByte(rest, 0)
+	if end < 0 {
+		return nil, nil, false
+	}
+	rest = rest[end:]
+	for len(rest) > 0 && rest[0] == 0 {
+		rest = rest[1:]
+	}
+
+	for len(rest) > 0 {
+		end := bytes.IndexByte(rest, 0)
+		if end < 0 {
+			end = len(rest)
+		}
+		if end == 0 && len(args) >= argc {
+			// the environment is terminated by an empty string
+			break
+		}
+		if len(args) < argc {
+			args = append(args, string(rest[:end]))
+		} else {
+			env = append(env, string(rest[:end]))
+		}
+		if end == len(rest) {
+			break
+		}
+		rest = rest[end+1:]
+	}
+	if len(args) == 0 {
+		return nil, nil, false
+	}
+	return args, env, true
+}
+
+func commName(comm [17]int8) string {
+	b := make([]byte, 0, len(comm))
+	for _, c := range comm {
+		if c == 0 {
+			break
+		}
+		b = append(b, byte(c))
+	}
+	return string(b)
+}
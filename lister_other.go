@@ -0,0 +1,12 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package main
+
+import "runtime"
+
+const defaultBackend = "ps"
+
+var procListers = map[string]func() procLister{
+	"ps": func() procLister { return psLister{threads: runtime.GOOS == "linux"} },
+}
@@ -0,0 +1,199 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// JOBOBJECTINFOCLASS value for JOBOBJECT_BASIC_PROCESS_ID_LIST
+const jobObjectBasicProcessIdList = 3
+
+const defaultBackend = "toolhelp"
+
+var procListers = map[string]func() procLister{
+	"toolhelp": func() procLister { return &toolhelpLister{owners: make(map[uint32]processOwner)} },
+}
+
+// toolhelpLister lists processes using a Toolhelp32 snapshot, and scopes the
+// command with a job object so that descendants are tracked even after their
+// parent exits (Windows does not reparent orphans).
+type toolhelpLister struct {
+	job windows.Handle
+	// owners caches the owner and image path of each pid, since looking
+	// them up requires opening the process
+	owners map[uint32]processOwner
+}
+
+type processOwner struct {
+	exeFile, user, image string
+}
+
+func (l *toolhelpLister) listProcs() (map[int]proc, error) {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not snapshot processes: %s", err)
+	}
+	defer windows.CloseHandle(snapshot)
+
+	procs := make(map[int]proc)
+	live := make(map[uint32]bool)
+	var entry windows.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	for err = windows.Process32First(snapshot, &entry); err == nil; err = windows.Process32Next(snapshot, &entry) {
+		exeFile := windows.UTF16ToString(entry.ExeFile[:])
+		owner := l.owner(entry.ProcessID, exeFile)
+		live[entry.ProcessID] = true
+		procs[int(entry.ProcessID)] = proc{
+			User:    owner.user,
+			Pid:     int(entry.ProcessID),
+			Ppid:    int(entry.ParentProcessID),
+			Threads: int(entry.Threads),
+			Command: owner.image,
+		}
+	}
+	if err != windows.ERROR_NO_MORE_FILES {
+		return nil, fmt.Errorf("could not enumerate processes: %s", err)
+	}
+
+	for pid := range l.owners {
+		if !live[pid] {
+			delete(l.owners, pid)
+		}
+	}
+	return procs, nil
+}
+
+// owner looks up the account and full image path of pid, falling back to the
+// snapshot's executable name when the process can't be opened.
+func (l *toolhelpLister) owner(pid uint32, exeFile string) processOwner {
+	if o, ok := l.owners[pid]; ok && o.exeFile == exeFile {
+		return o
+	}
+	o := processOwner{exeFile: exeFile, image: exeFile}
+	defer func() { l.owners[pid] = o }()
+
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return o
+	}
+	defer windows.CloseHandle(h)
+
+	buf := make([]uint16, windows.MAX_LONG_PATH)
+	size := uint32(len(buf))
+	if err := windows.QueryFullProcessImageName(h, 0, &buf[0], &size); err == nil {
+		o.image = windows.UTF16ToString(buf[:size])
+	}
+
+	var token windows.Token
+	if err := windows.OpenProcessToken(h, windows.TOKEN_QUERY, &token); err != nil {
+		return o
+	}
+	defer token.Close()
+	tokenUser, err := token.GetTokenUser()
+	if err != nil {
+		return o
+	}
+	if account, domain, _, err := tokenUser.User.Sid.LookupAccount(""); err == nil {
+		o.user = domain + `\` + account
+	}
+	return o
+}
+
+// prepare has cmd start suspended, so that it can't start any processes
+// before scope has assigned it to the job object.
+func (l *toolhelpLister) prepare(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= windows.CREATE_SUSPENDED
+	return nil
+}
+
+func (l *toolhelpLister) scope(cmd *exec.Cmd) (err error) {
+	// however scoping goes, the command must be resumed to run at all
+	defer func() {
+		if resumeErr := resumeProcess(uint32(cmd.Process.Pid)); resumeErr != nil && err == nil {
+			err = resumeErr
+		}
+	}()
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return fmt.Errorf("could not create job object: %s", err)
+	}
+	h, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		windows.CloseHandle(job)
+		return fmt.Errorf("could not open %s: %s", filepath.Base(cmd.Path), err)
+	}
+	defer windows.CloseHandle(h)
+	if err := windows.AssignProcessToJobObject(job, h); err != nil {
+		windows.CloseHandle(job)
+		return fmt.Errorf("could not assign %s to job object: %s", filepath.Base(cmd.Path), err)
+	}
+	l.job = job
+	return nil
+}
+
+// resumeProcess resumes the threads of a process started suspended.
+func resumeProcess(pid uint32) error {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPTHREAD, 0)
+	if err != nil {
+		return fmt.Errorf("could not snapshot threads: %s", err)
+	}
+	defer windows.CloseHandle(snapshot)
+
+	var entry windows.ThreadEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	for err = windows.Thread32First(snapshot, &entry); err == nil; err = windows.Thread32Next(snapshot, &entry) {
+		if entry.OwnerProcessID != pid {
+			continue
+		}
+		thread, err := windows.OpenThread(windows.THREAD_SUSPEND_RESUME, false, entry.ThreadID)
+		if err != nil {
+			return fmt.Errorf("could not open thread %d: %s", entry.ThreadID, err)
+		}
+		_, err = windows.ResumeThread(thread)
+		windows.CloseHandle(thread)
+		if err != nil {
+			return fmt.Errorf("could not resume thread %d: %s", entry.ThreadID, err)
+		}
+	}
+	return nil
+}
+
+func (l *toolhelpLister) scopedPids() ([]int, error) {
+	if l.job == 0 {
+		return nil, nil
+	}
+
+	// JOBOBJECT_BASIC_PROCESS_ID_LIST is two uint32 counts followed by a
+	// variable-length array of ULONG_PTR pids; grow the buffer until it fits
+	header := int(8 / unsafe.Sizeof(uintptr(0)))
+	capacity := 64
+	for {
+		buf := make([]uintptr, header+capacity)
+		err := windows.QueryInformationJobObject(l.job, jobObjectBasicProcessIdList, uintptr(unsafe.Pointer(&buf[0])), uint32(len(buf))*uint32(unsafe.Sizeof(buf[0])), nil)
+		counts := (*[2]uint32)(unsafe.Pointer(&buf[0]))
+		assigned, listed := counts[0], counts[1]
+		if err == windows.ERROR_MORE_DATA || (err == nil && listed < assigned) {
+			capacity = 2 * (capacity + int(assigned))
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not list job object processes: %s", err)
+		}
+		pids := make([]int, listed)
+		for i := range pids {
+			pids[i] = int(buf[header+i])
+		}
+		return pids, nil
+	}
+}
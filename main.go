@@ -3,12 +3,15 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -21,88 +24,770 @@ type proc struct {
 	Pgid     int    `json:"pgid"`
 	Command  string `json:"command"`
 	Children []int  `json:"children"`
+
+	// Zombie is set for processes that have exited but haven't yet been
+	// reaped by their parent
+	Zombie bool `json:"zombie,omitempty"`
+	// RSS is the resident set size in bytes, or 0 if the backend can't
+	// determine it
+	RSS int64 `json:"rss,omitempty"`
+	// Threads is 0 if the backend can't determine thread counts
+	Threads int `json:"threads,omitempty"`
+	// CPUTime is the user and system time used so far, only sampled with
+	// -cpu
+	CPUTime *time.Duration `json:"cpu_time,omitempty"`
+	// IO is only sampled with -io
+	IO *ioCounters `json:"io,omitempty"`
+	// Env holds the variables requested with -capture-env
+	Env map[string]string `json:"env,omitempty"`
+	// Extra holds the additional fields requested with -columns
+	Extra map[string]string `json:"extra,omitempty"`
+	// Cgroup and Container are only sampled with -containers
+	Cgroup    string         `json:"cgroup,omitempty"`
+	Container *containerInfo `json:"container,omitempty"`
 }
 
 type sample struct {
 	At    time.Time    `json:"at"`
 	Procs map[int]proc `json:"procs"`
+	// Cgroup is only sampled by the cgroup backend
+	Cgroup *cgroupStats `json:"cgroup,omitempty"`
+	// Events are the forks, execs and exits since the previous sample, only
+	// recorded with -events
+	Events []procEvent `json:"events,omitempty"`
+	// Merged is the number of samples combined into this one by
+	// -max-samples, or 0 if it wasn't downsampled
+	Merged int `json:"merged,omitempty"`
+	// Output holds the lines the command wrote since the previous sample,
+	// with -tee
+	Output []outputLine `json:"output,omitempty"`
+	// TimedOut is set on the samples taken after -timeout elapsed, while the
+	// command was being stopped
+	TimedOut bool `json:"timed_out,omitempty"`
 }
 
-func main() {
-	command := flag.String("cmd", "", "Command to run")
-	outputFmt := flag.String("fmt", "count", "Output format to summarize samples")
-	freq := flag.Int("freq", 100, "Sampling frequency in Hertz")
-	flag.Parse()
+// weight is the number of samples that s stands for.
+func (s sample) weight() int {
+	if s.Merged == 0 {
+		return 1
+	}
+	return s.Merged
+}
 
-	if *command == "" {
-		flag.Usage()
+// runProfile implements `pstree_prof run`, running a command and reporting
+// on the processes it starts.
+func runProfile(args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s run [flags] -- command [args...]\n", NAME)
+		flags.PrintDefaults()
+	}
+	command := flags.String("cmd", "", "Command to run, split into arguments like a shell would (alternative to passing it after --)")
+	interval := flags.Duration("interval", 10*time.Millisecond, "Time between samples, e.g. 250ms or 1s")
+	freq := flags.Float64("freq", 0, "Sampling frequency in Hertz (alternative to -interval)")
+	backend := flags.String("backend", "", "How to enumerate processes: "+strings.Join(backendNames(), ", ")+", or "+fixtureBackend+"run.ndjson to replay a recorded run, one sample per interval, while the command runs (default "+defaultBackend+")")
+	followReparented := flags.Bool("follow-reparented", true, "Keep tracking descendants that were reparented (e.g. by double-forking) using their previous sample and process group")
+	sampleIO := flags.Bool("io", false, "Sample I/O counters and open file descriptors of each process, for -fmt io (Linux only)")
+	sampleCPU := flags.Bool("cpu", false, "Sample the CPU time used by each process, for -fmt cpu (Linux only)")
+	captureEnv := flags.String("capture-env", "", "Comma-separated environment variables to record for each process (Linux and macOS only)")
+	maxProcs := flags.Int("max-procs", 0, "Exit non-zero if more than this many processes are started (0 means unlimited)")
+	maxDuration := flags.Duration("max-duration", 0, "Exit non-zero if the command runs for longer than this (0 means unlimited)")
+	maxConcurrency := flags.Int("max-concurrency", 0, "Exit non-zero if more than this many processes run at once (0 means unlimited)")
+	flushEvery := flags.Duration("flush-every", 0, "Write the reports for the samples so far to timestamped files every so often and discard them, to bound memory use when wrapping long-running commands. Requires -o; budgets only apply to the final period")
+	sampleContainers := flags.Bool("containers", false, "Record the cgroup and container of each process, for -fmt containers (Linux only)")
+	maxSamples := flags.Int("max-samples", 0, "Keep at most this many samples in memory by merging adjacent ones, halving the effective rate each time the limit is hit (0 means unlimited)")
+	trackEvents := flags.Bool("events", false, "Also record fork/exec/exit events from the kernel, so processes shorter than the sampling interval are seen and lifetimes are accurate (Linux only, requires root)")
+	columns := flags.String("columns", "", "Comma-separated extra `ps -o` fields to capture for each process, e.g. %cpu,rss,state (ps backends only; fields must not contain spaces)")
+	strict := flags.Bool("strict", false, "Exit on the first line of `ps` output that can't be parsed, instead of logging and skipping it")
+	delayStart := flags.Duration("delay-start", 0, "Wait this long after starting the command before sampling, to skip a warmup phase")
+	sampleWindow := flags.Duration("duration", 0, "Stop sampling and write the reports after this long, even if the command is still running (it is then left to finish); 0 means until it exits")
+	timeout := flags.Duration("timeout", 0, "Stop the command and its descendants after this long, with SIGTERM and then SIGKILL "+timeoutGrace.String()+" later, exiting non-zero (0 means no timeout)")
+	adaptive := flags.Bool("adaptive", false, "Sample faster while processes are starting and ending, and slower while the tree is stable, starting from -interval")
+	minInterval := flags.Duration("min-interval", time.Millisecond, "The shortest interval -adaptive may sample at")
+	maxInterval := flags.Duration("max-interval", time.Second, "The longest interval -adaptive may sample at")
+	metricsAddr := flags.String("metrics-addr", "", "Serve Prometheus metrics about the processes being sampled on this address, e.g. :9090, at /metrics")
+	onlyUser := flags.String("user", "self", "Only sample processes owned by this user name or uid (self being the user running pstree_prof), so that other users' processes picked up by -follow-reparented are left out. Set to any to sample every user's, e.g. for commands run with sudo")
+	tee := flags.Bool("tee", false, "Pass the command's output through pstree_prof, recording when each line was written and how many processes were live, for the ndjson, svg and trace reports")
+	killOrphans := flags.Bool("kill-orphans", false, "Kill the command's process group and any of its descendants that are still running once it exits, or if pstree_prof fails first")
+	numRuns := flags.Int("runs", 1, "Run the command this many times, writing the reports for each run (to -o with the run number appended) and then the mean and standard deviation of each command's invocations and lifetimes across runs")
+	execHook := flags.String("exec-hook", "", "Command to pipe each sample to as a line of JSON while sampling, split into arguments like -cmd, e.g. 'mytool --ingest'")
+	execHookFmt := flags.String("exec-hook-fmt", "", "Pipe this report to -exec-hook once sampling ends, instead of each sample")
+	exitZero := flags.Bool("exit-zero", false, "Always exit 0 instead of with the command's exit code")
+	rf := addReportFlags(flags, true)
+	parseFlags(flags, args)
+
+	commandParts := flags.Args()
+	if *command != "" {
+		if len(commandParts) > 0 {
+			flags.Usage()
+			log.Fatalln("-cmd cannot be combined with a command after --")
+		}
+		var err error
+		commandParts, err = splitCommand(*command)
+		if err != nil {
+			log.Fatalf("invalid -cmd: %s\n", err)
+		}
+	}
+	if len(commandParts) == 0 {
+		flags.Usage()
 		log.Fatalln("a non-empty command must be specified")
 	}
 
-	log.SetPrefix(fmt.Sprintf("%s: ", NAME))
+	r := rf.reporter(flags)
+
+	if *sampleIO && !ioCountersSupported {
+		log.Fatalln("-io is only supported on Linux")
+	}
+	if *sampleCPU && !cpuTimeSupported {
+		log.Fatalln("-cpu is only supported on Linux")
+	}
+
+	envKeys := splitList(*captureEnv)
+	if len(envKeys) > 0 && !envCaptureSupported {
+		log.Fatalln("-capture-env is only supported on Linux and macOS")
+	}
+
+	if *sampleContainers && !containersSupported {
+		log.Fatalln("-containers is only supported on Linux")
+	}
+
+	if *trackEvents && !procEventsSupported {
+		log.Fatalln("-events is only supported on Linux")
+	}
+
+	extraColumns, err := parseColumns(*columns)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	lister, err := newProcLister(*backend, psOptions{columns: extraColumns, strict: *strict})
+	if err != nil {
+		flags.Usage()
+		log.Fatalln(err)
+	}
+	if _, ok := lister.(*fakeLister); ok {
+		err := checkFakeFlags(map[string]bool{
+			"io":           *sampleIO,
+			"cpu":          *sampleCPU,
+			"capture-env":  len(envKeys) > 0,
+			"containers":   *sampleContainers,
+			"events":       *trackEvents,
+			"timeout":      *timeout > 0,
+			"kill-orphans": *killOrphans,
+		})
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if *delayStart < 0 || *sampleWindow < 0 || *timeout < 0 {
+		log.Fatalln("-delay-start, -duration and -timeout must not be negative")
+	}
+	if *numRuns < 1 {
+		log.Fatalln("-runs must be at least 1")
+	}
+	if *maxSamples < 0 || *maxSamples == 1 {
+		log.Fatalln("-max-samples must be 0 or at least 2")
+	}
+	if *flushEvery > 0 && r.out == "" {
+		log.Fatalln("-flush-every requires -o")
+	}
+	if *flushEvery > 0 && *flushEvery < time.Second {
+		// flushed files are named to the second
+		log.Fatalln("-flush-every must be at least 1s")
+	}
+
+	delay, err := samplingInterval(*interval, *freq, isFlagSet(flags, "interval"), isFlagSet(flags, "freq"))
+	if err != nil {
+		flags.Usage()
+		log.Fatalln(err)
+	}
+	log.Printf("sampling every %s\n", delay)
+
+	r.opts.envKeys = envKeys
+	r.opts.columns = extraColumns
+
+	cfg := profileConfig{
+		command:          commandParts,
+		interval:         delay,
+		followReparented: *followReparented,
+		sampleIO:         *sampleIO,
+		sampleCPU:        *sampleCPU,
+		envKeys:          envKeys,
+		containers:       *sampleContainers,
+		events:           *trackEvents,
+		maxSamples:       *maxSamples,
+		flushEvery:       *flushEvery,
+		delayStart:       *delayStart,
+		duration:         *sampleWindow,
+		timeout:          *timeout,
+		killOrphans:      *killOrphans,
+		tee:              *tee,
+		backend:          *backend,
+	}
+	onlyUserName := *onlyUser
+	if _, ok := lister.(*fakeLister); ok && !isFlagSet(flags, "user") {
+		// a recorded run's processes may belong to anyone
+		onlyUserName = "any"
+	}
+	if onlyUserName != "any" {
+		cfg.user, err = newUserFilter(onlyUserName)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *adaptive {
+		if *minInterval <= 0 || *maxInterval < *minInterval {
+			log.Fatalln("-min-interval must be positive and no more than -max-interval")
+		}
+		cfg.adaptive = &adaptiveInterval{min: *minInterval, max: *maxInterval}
+	}
+	if *metricsAddr != "" {
+		cfg.metrics, err = serveMetrics(*metricsAddr, r.opts.normalize)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	var hookCommand []string
+	if *execHook != "" {
+		hookCommand, err = splitCommand(*execHook)
+		if err != nil {
+			log.Fatalf("invalid -exec-hook: %s\n", err)
+		}
+		if len(hookCommand) == 0 {
+			log.Fatalln("-exec-hook must not be empty")
+		}
+	}
+	if *execHookFmt != "" {
+		if hookCommand == nil {
+			log.Fatalln("-exec-hook-fmt requires -exec-hook")
+		}
+		if format, ok := outputFormats[*execHookFmt]; !ok || format.stream {
+			log.Fatalf("invalid -exec-hook-fmt %q (expected one of %s, other than stream)\n", *execHookFmt, strings.Join(formatNames(), ", "))
+		}
+	}
+	report := func(samples []sample, out string) []sample {
+		samples = r.write(samples, out)
+		if *execHookFmt != "" {
+			err := runExecHook(hookCommand, func(w io.Writer) error {
+				return outputFormats[*execHookFmt].write(w, samples, r.opts)
+			})
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		return samples
+	}
+
+	var runs [][]sample
+	var exitCode int
+	for run := 1; run <= *numRuns; run++ {
+		out := r.out
+		if *numRuns > 1 {
+			log.Printf("starting run %d of %d\n", run, *numRuns)
+			if out != "" {
+				out = runPath(out, run)
+			}
+			if run > 1 {
+				// listers may hold state about the previous run
+				lister, _ = newProcLister(*backend, psOptions{columns: extraColumns, strict: *strict})
+			}
+		}
+		var streams []*sampleStream
+		for _, name := range r.formats {
+			if outputFormats[name].stream {
+				stream, err := openSampleStream(reportPath(out, name, len(r.formats)))
+				if err != nil {
+					log.Fatalf("could not open stream: %s\n", err)
+				}
+				streams = append(streams, stream)
+			}
+		}
+		if hookCommand != nil && *execHookFmt == "" {
+			stream, err := startExecHook(hookCommand)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			streams = append(streams, stream)
+		}
+		cfg.onSample = nil
+		if len(streams) > 0 {
+			cfg.onSample = func(s sample) {
+				s = r.prepare([]sample{s})[0]
+				for _, stream := range streams {
+					stream.write(s)
+				}
+			}
+		}
+		cfg.flush = func(samples []sample, chunkStart time.Time) {
+			path := rotatedPath(out, chunkStart)
+			report(samples, path)
+			log.Printf("flushed %d samples to %s\n", len(samples), path)
+		}
 
-	delay := 1000 / *freq
-	log.Printf("sampling every %dms\n", delay)
-	delayMS := time.Duration(delay) * time.Millisecond
+		samples, chunkStart, wait := profileCommand(lister, cfg)
+		if *flushEvery > 0 {
+			out = rotatedPath(out, chunkStart)
+		}
+		samples = report(samples, out)
+		runs = append(runs, samples)
+		for _, stream := range streams {
+			if err := stream.Close(); err != nil {
+				log.Println(err)
+			}
+		}
+		code, timedOut := wait()
 
-	samples := make([]sample, 1)
-	commandParts := strings.Split(*command, " ")
-	cmd, err := startCommandInBackground(commandParts[0], commandParts[1:], func() {
-		switch *outputFmt {
-		case "count":
-			printProcCounts(samples)
-		case "starts_and_ends":
-			printProcStartsAndEnds(samples)
-		case "trace":
-			exportSamplesAsTraces(samples)
-		default:
-			log.Fatalf("unrecognized outputMode: %s\n", *outputFmt)
+		if *exitZero {
+			code = 0
+		}
+		violations := checkBudgets(samples, budgets{
+			procs:       *maxProcs,
+			duration:    *maxDuration,
+			concurrency: *maxConcurrency,
+		})
+		for _, violation := range violations {
+			log.Printf("budget exceeded: %s\n", violation)
+		}
+		if len(violations) > 0 && code == 0 {
+			code = 1
 		}
-		os.Exit(0) // why do I need to do this?
+		if timedOut && code == 0 {
+			code = 124
+		}
+		if exitCode == 0 {
+			exitCode = code
+		}
+	}
+	if len(runs) > 1 {
+		if err := writeRunStats(r.out, runs, r.opts); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	os.Exit(exitCode)
+}
+
+// onFatal, if set, cleans up after the command being profiled before fatal
+// exits.
+var onFatal func()
+
+// fatal is log.Fatalln for errors that can happen once the command has
+// started.
+func fatal(v ...interface{}) {
+	log.Println(v...)
+	if onFatal != nil {
+		onFatal()
+	}
+	os.Exit(1)
+}
+
+// timeoutGrace is how long a command has to exit after being sent SIGTERM by
+// -timeout before it is killed.
+const timeoutGrace = 5 * time.Second
+
+// profileConfig holds the flags that control how a command is profiled.
+type profileConfig struct {
+	command          []string
+	interval         time.Duration
+	followReparented bool
+	sampleIO         bool
+	sampleCPU        bool
+	envKeys          []string
+	containers       bool
+	events           bool
+	maxSamples       int
+	flushEvery       time.Duration
+	// user, if set, restricts sampling to the processes of one user
+	user *userFilter
+	// timeout is how long the command may run before it is terminated
+	timeout time.Duration
+	// tee records the command's output
+	tee bool
+	// killOrphans kills whatever is left of the command's process tree once
+	// it exits, or if pstree_prof exits first
+	killOrphans bool
+	// backend names the lister, to check which processes are still running
+	// before signalling them
+	backend string
+	// delayStart is how long to wait before the first sample, and duration
+	// how long to sample for (0 meaning until the command exits)
+	delayStart time.Duration
+	duration   time.Duration
+	// adaptive, if set, bounds the interval as it is adapted to how quickly
+	// the tree is changing
+	adaptive *adaptiveInterval
+	// metrics, if set, is updated with each sample
+	metrics *metrics
+	// onSample, if set, is called with each sample as it is captured
+	onSample func(s sample)
+	// flush is called with the samples of each -flush-every period but the
+	// last, which is returned
+	flush func(samples []sample, chunkStart time.Time)
+}
+
+// profileCommand runs the command, sampling its processes with lister until it
+// exits or the -duration window ends, and returns the samples (since the last
+// flush) and when the period they belong to started. wait waits for the
+// command to exit, if it's still running, and returns its exit code and
+// whether it was stopped by -timeout.
+func profileCommand(lister procLister, cfg profileConfig) (samples []sample, chunkStart time.Time, wait func() (int, bool)) {
+	// start listening for events before starting the command, so that none
+	// of its children are missed
+	var events *procEventSource
+	if cfg.events {
+		var err error
+		events, err = startProcEvents()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	exited := make(chan int, 1)
+	done := make(chan struct{})
+	var output *outputRecorder
+	if cfg.tee {
+		output = newOutputRecorder()
+	}
+	var prepare func(cmd *exec.Cmd) error
+	if preparer, ok := lister.(commandPreparer); ok {
+		prepare = preparer.prepare
+	}
+	cmd, err := startCommandInBackground(cfg.command[0], cfg.command[1:], prepare, output, func(exitCode int) {
+		exited <- exitCode
+		close(done)
 	})
 	if err != nil {
+		// undo whatever prepare set up
+		if closer, ok := lister.(io.Closer); ok {
+			closer.Close()
+		}
 		log.Fatalln(err)
 	}
 
+	// the timeout is enforced separately from sampling, so that it still
+	// applies during -delay-start and after the -duration window
+	var mu sync.Mutex
+	var latestProcs map[int]proc
+	timedOut := false
+	if cfg.timeout > 0 {
+		go func() {
+			select {
+			case <-time.After(cfg.timeout):
+			case <-done:
+				return
+			}
+			mu.Lock()
+			timedOut = true
+			procs := latestProcs
+			mu.Unlock()
+			log.Printf("command timed out after %s, sending SIGTERM\n", cfg.timeout)
+			terminateCommand(cmd, stillRunning(cfg.backend, procs))
+
+			select {
+			case <-time.After(timeoutGrace):
+			case <-done:
+				return
+			}
+			mu.Lock()
+			procs = latestProcs
+			mu.Unlock()
+			log.Printf("command still running %s after SIGTERM, sending SIGKILL\n", timeoutGrace)
+			killCommand(cmd, stillRunning(cfg.backend, procs))
+		}()
+	}
+
+	stopForwarding := forwardSignals(cmd)
+	if cfg.killOrphans {
+		onFatal = func() {
+			mu.Lock()
+			defer mu.Unlock()
+			killCommand(cmd, stillRunning(cfg.backend, latestProcs))
+		}
+	}
+
+	if scoper, ok := lister.(commandScoper); ok {
+		if err := scoper.scope(cmd); err != nil {
+			fatal(err)
+		}
+	}
+
+	var eventsFilter *eventFilter
+	if events != nil {
+		eventsFilter = newEventFilter(cmd.Process.Pid)
+	}
+
+	var containers *containerNames
+	if cfg.containers {
+		containers = newContainerNames()
+	}
+
+	var exitCode int
+	running := true
+	wait = func() (int, bool) {
+		if running {
+			log.Println("sampling window ended, waiting for the command to exit")
+			exitCode = <-exited
+		}
+		stopForwarding()
+		if cfg.killOrphans {
+			mu.Lock()
+			killCommand(cmd, stillRunning(cfg.backend, latestProcs))
+			mu.Unlock()
+			onFatal = nil
+		}
+		if closer, ok := lister.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Println(err)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return exitCode, timedOut
+	}
+
+	if cfg.delayStart > 0 {
+		select {
+		case exitCode = <-exited:
+			log.Println("command exited before -delay-start elapsed")
+			running = false
+			if events != nil {
+				events.Close()
+			}
+			return nil, time.Now(), wait
+		case <-time.After(cfg.delayStart):
+		}
+		if events != nil {
+			// keep track of the processes forked during the delay, so that
+			// later events about them aren't dropped
+			evs, err := events.drain(time.Now())
+			if err != nil {
+				log.Println(err)
+			}
+			eventsFilter.keep(evs)
+		}
+	}
+
+	var window <-chan time.Time
+	if cfg.duration > 0 {
+		window = time.After(cfg.duration)
+	}
+
 	var lastSample sample
+	chunkStart = time.Now()
+	ticker := time.NewTicker(cfg.interval)
+	stats := &samplerStats{interval: cfg.interval, adaptive: cfg.adaptive != nil}
+	var adaptive *adaptiveInterval
+	if cfg.adaptive != nil {
+		adaptive = newAdaptiveInterval(cfg.interval, cfg.adaptive.min, cfg.adaptive.max)
+	}
+	downsampler := newDownsampler(cfg.maxSamples)
+sampling:
 	for {
-		lastSample = sampleProcs(cmd.Process.Pid, lastSample)
-		samples = append(samples, lastSample)
-		time.Sleep(delayMS)
+		started := time.Now()
+		next, err := sampleProcs(lister, cmd.Process.Pid, lastSample, cfg.followReparented, cfg.user)
+		if err != nil {
+			fatal(err)
+		}
+		if cfg.sampleIO {
+			annotateIOCounters(next)
+		}
+		if cfg.sampleCPU {
+			annotateCPUTime(next)
+		}
+		if len(cfg.envKeys) > 0 {
+			annotateEnv(next, lastSample, cfg.envKeys)
+		}
+		if containers != nil {
+			annotateContainers(next, lastSample, containers)
+		}
+		if annotator, ok := lister.(sampleAnnotator); ok {
+			annotator.annotate(&next)
+		}
+		if events != nil {
+			evs, err := events.drain(next.At)
+			if err != nil {
+				log.Println(err)
+			}
+			next.Events = eventsFilter.keep(evs)
+			eventsFilter.update(next)
+		}
+		if output != nil {
+			next.Output = output.drain(false)
+			output.setLive(len(next.Procs))
+		}
+		mu.Lock()
+		next.TimedOut = timedOut
+		latestProcs = next.Procs
+		mu.Unlock()
+		busy := time.Since(started)
+		stats.record(started, busy)
+		if cfg.metrics != nil {
+			cfg.metrics.record(next, busy)
+		}
+		if cfg.onSample != nil {
+			cfg.onSample(next)
+		}
+		if adaptive != nil {
+			if interval := adaptive.next(churn(lastSample, next)); interval != stats.interval {
+				ticker.Reset(interval)
+				stats.interval = interval
+			}
+		}
+		lastSample = next
+		stride := downsampler.stride
+		samples = downsampler.add(samples, lastSample)
+		if downsampler.stride != stride {
+			log.Printf("reached -max-samples %d, now merging every %d samples\n", cfg.maxSamples, downsampler.stride)
+		}
+
+		if cfg.flushEvery > 0 && lastSample.At.Sub(chunkStart) >= cfg.flushEvery {
+			cfg.flush(samples, chunkStart)
+			// start the next chunk from the latest sample so that processes
+			// spanning both aren't counted as starting afresh
+			samples = []sample{lastSample}
+			downsampler = newDownsampler(cfg.maxSamples)
+			chunkStart = lastSample.At
+		}
+
+		select {
+		case exitCode = <-exited:
+			running = false
+			break sampling
+		case <-window:
+			break sampling
+		case <-ticker.C:
+		}
+	}
+	ticker.Stop()
+	log.Println(stats)
+	if output != nil && len(samples) > 0 {
+		// the command has exited, so everything it wrote has been recorded
+		last := &samples[len(samples)-1]
+		last.Output = append(last.Output, output.drain(true)...)
 	}
+
+	if events != nil {
+		events.Close()
+	}
+	return samples, chunkStart, wait
 }
 
-func sampleProcs(pid int, lastSample sample) sample {
-	cols := []string{"user", "pid", "ppid", "pgid", "command"}
-	args := []string{"ps", "-axwwo", strings.Join(cols, ",")}
-	psCmd := exec.Command(args[0], args[1:]...)
-	psOut, err := psCmd.Output()
-	if err != nil {
-		log.Fatalln(fmt.Errorf("could not start `ps`: %s", err))
+// samplingInterval reconciles -interval and its -freq alias.
+func samplingInterval(interval time.Duration, freq float64, intervalSet, freqSet bool) (time.Duration, error) {
+	if intervalSet && freqSet {
+		return 0, fmt.Errorf("only one of -interval and -freq may be specified")
+	}
+	if freqSet {
+		if freq <= 0 {
+			return 0, fmt.Errorf("-freq must be positive, got %g", freq)
+		}
+		interval = time.Duration(float64(time.Second) / freq)
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("sampling interval must be positive, got %s", interval)
 	}
+	return interval, nil
+}
+
+// rotatedPath inserts the time a flushed period started into out, before its
+// extension, e.g. run.ndjson becomes run-20220408T114800.ndjson.
+func rotatedPath(out string, t time.Time) string {
+	ext := filepath.Ext(out)
+	return strings.TrimSuffix(out, ext) + "-" + t.Format("20060102T150405") + ext
+}
 
-	lines := strings.Split(string(psOut), "\n")
-	if len(lines) == 0 {
-		log.Fatalln("expected at least one line of output from `ps`")
+// writeRunStats writes the comparison of -runs to stdout, or with -o to a file
+// alongside the runs', so that it isn't mixed into the command's output: e.g.
+// run-runs.tsv beside run-1.ndjson and run-2.ndjson.
+func writeRunStats(out string, runs [][]sample, opts reportOptions) error {
+	if out == "" {
+		return printRunStats(os.Stdout, runs, opts)
+	}
+	path := strings.TrimSuffix(out, filepath.Ext(out)) + "-runs.tsv"
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not write the comparison of runs: %s", err)
+	}
+	if err := printRunStats(f, runs, opts); err != nil {
+		f.Close()
+		return err
 	}
+	log.Printf("wrote the comparison of runs to %s\n", path)
+	return f.Close()
+}
+
+// runPath inserts the number of a -runs run into out, before its extension,
+// e.g. run.ndjson becomes run-2.ndjson.
+func runPath(out string, run int) string {
+	ext := filepath.Ext(out)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(out, ext), run, ext)
+}
 
-	// skip header
-	lines = lines[1:]
-	// if last line is empty, skip
-	if lines[len(lines)-1] == "" {
-		lines = lines[:len(lines)-1]
+// splitList splits a comma-separated flag value, ignoring empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
+}
 
-	procs := make(map[int]proc)
-	for _, line := range lines {
-		proc := parseLineAsProc(line, cols)
+func isFlagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
 
-		if proc.Pid == psCmd.Process.Pid {
-			// not interested in the `ps ...` command that we started
-			continue
+// stillRunning returns the processes in procs that are still running the
+// same command, listing them afresh with backend. procs come from the latest
+// sample, which may have been taken long before (after a -duration window,
+// say), so their pids may since have been reused by unrelated processes.
+func stillRunning(backend string, procs map[int]proc) map[int]proc {
+	if len(procs) == 0 {
+		return nil
+	}
+	var current map[int]proc
+	lister, err := newProcLister(backend, psOptions{})
+	if err == nil {
+		current, err = lister.listProcs()
+	}
+	if err != nil {
+		log.Printf("could not check which processes are still running, only signalling the command's process group: %s\n", err)
+		return nil
+	}
+	running := make(map[int]proc)
+	for pid, p := range procs {
+		if now, ok := current[pid]; ok && now.Command == p.Command {
+			running[pid] = p
 		}
+	}
+	return running
+}
 
-		procs[proc.Pid] = proc
+// sampleProcs captures the tree of processes rooted at pid, plus any that
+// lister reports as belonging to the command. If
+// followReparented is set, processes from lastSample that are still running
+// and processes sharing a process group with one that was tracked are also
+// included, even if they are no longer descendants of pid. If user is set,
+// processes owned by anyone else are left out, along with their descendants.
+func sampleProcs(lister procLister, pid int, lastSample sample, followReparented bool, user *userFilter) (sample, error) {
+	at := time.Now()
+	procs, err := lister.listProcs()
+	if err != nil {
+		return sample{}, err
+	}
+	roots := []int{pid}
+	if scoper, ok := lister.(commandScoper); ok {
+		scoped, err := scoper.scopedPids()
+		if err != nil {
+			return sample{}, err
+		}
+		roots = append(roots, scoped...)
 	}
 
 	for pid, proc := range procs {
@@ -112,21 +797,34 @@ func sampleProcs(pid int, lastSample sample) sample {
 		}
 	}
 
+	if followReparented {
+		roots = append(roots, reparentedProcs(procs, pid, lastSample)...)
+	}
+
 	type pidToVisit struct {
 		pid, depth int
 	}
-	pidsToVisit := []pidToVisit{
-		{pid, 0},
+	pidsToVisit := make([]pidToVisit, len(roots))
+	for i, root := range roots {
+		pidsToVisit[i] = pidToVisit{root, 0}
 	}
 
-	sample := sample{At: time.Now(), Procs: make(map[int]proc)}
+	sample := sample{At: at, Procs: make(map[int]proc)}
 	for len(pidsToVisit) > 0 {
 		pid := pidsToVisit[0]
 		pidsToVisit = pidsToVisit[1:]
 		if _, ok := sample.Procs[pid.pid]; ok {
 			continue
 		}
-		proc := procs[pid.pid]
+		proc, ok := procs[pid.pid]
+		if !ok {
+			// exited between being listed as a child and now, or the root
+			// command has already been reaped
+			continue
+		}
+		if user != nil && !user.matches(proc) {
+			continue
+		}
 		sample.Procs[pid.pid] = proc
 
 		newPidsToVisit := make([]pidToVisit, len(proc.Children))
@@ -137,48 +835,43 @@ func sampleProcs(pid int, lastSample sample) sample {
 		pidsToVisit = append(newPidsToVisit, pidsToVisit...)
 	}
 
-	return sample
+	return sample, nil
 }
 
-func parseLineAsProc(line string, cols []string) proc {
-	var colStart, col int
-	prevWasSpace := false
-	parsedCols := make([]string, len(cols))
-	for i, c := range line {
-		if col == len(cols)-1 {
-			// final column, don't need to search for the end
-			// abc___def___ghi
-			//    	       ^
-			parsedCols[col] = line[i:]
-			break
-		}
-
-		if !prevWasSpace && c == ' ' {
-			// first space char after a string of non-spaces, i.e. the start of the column padding
-			// abc___def___ghi
-			//    ^
-			parsedCols[col] = line[colStart:i]
-			col += 1
-			prevWasSpace = true
-		} else if prevWasSpace && c != ' ' {
-			// first non-space after a string of spaces, i.e. the start of a new column
-			// abc___def___ghi
-			//       ^
-			colStart = i
-			prevWasSpace = false
-		}
-	}
-
-	return proc{
-		User:    parsedCols[0],
-		Pid:     strictAtoi(parsedCols[1]),
-		Ppid:    strictAtoi(parsedCols[2]),
-		Pgid:    strictAtoi(parsedCols[3]),
-		Command: parsedCols[4],
+// reparentedProcs returns the pids in procs that originated from the command
+// rooted at pid but may no longer be its descendants: those that were tracked
+// in lastSample and are still running the same command, and those in the same
+// process group as a tracked process. Our own process group is ignored, since
+// the command inherits it unless it starts a new one.
+func reparentedProcs(procs map[int]proc, pid int, lastSample sample) []int {
+	ownPgid := -1
+	if self, ok := procs[os.Getpid()]; ok {
+		ownPgid = self.Pgid
+	}
+
+	trackedPgids := make(map[int]bool)
+	if root, ok := procs[pid]; ok && root.Pgid != ownPgid {
+		trackedPgids[root.Pgid] = true
+	}
+	var pids []int
+	for _, prev := range lastSample.Procs {
+		if p, ok := procs[prev.Pid]; ok && p.Command == prev.Command {
+			pids = append(pids, p.Pid)
+		}
+		if prev.Pgid != ownPgid {
+			trackedPgids[prev.Pgid] = true
+		}
 	}
+	for _, p := range procs {
+		if trackedPgids[p.Pgid] {
+			pids = append(pids, p.Pid)
+		}
+	}
+	sort.Ints(pids)
+	return pids
 }
 
-func printProcCounts(samples []sample) {
+func printProcCounts(w io.Writer, samples []sample, opts reportOptions) error {
 	type countAndCommand struct {
 		count int
 		cmd   string
@@ -187,9 +880,13 @@ func printProcCounts(samples []sample) {
 	for _, sample := range samples {
 		for _, proc := range sample.Procs {
 			if cc, ok := counts[proc.Pid]; ok {
-				counts[proc.Pid] = countAndCommand{count: cc.count + 1, cmd: cc.cmd}
+				counts[proc.Pid] = countAndCommand{count: cc.count + sample.weight(), cmd: cc.cmd}
 			} else {
-				counts[proc.Pid] = countAndCommand{count: 1, cmd: proc.Command}
+				cmd := proc.Command
+				if opts.showAncestry {
+					cmd = ancestryPath(sample.Procs, proc, func(command string) string { return command })
+				}
+				counts[proc.Pid] = countAndCommand{count: sample.weight(), cmd: cmd}
 			}
 		}
 	}
@@ -203,19 +900,20 @@ func printProcCounts(samples []sample) {
 		return countsAndCommands[i].count > countsAndCommands[j].count
 	})
 
-	fmt.Println("count\tcommand")
+	fmt.Fprintln(w, "count\tcommand")
 	for _, cAndC := range countsAndCommands {
 		if cAndC.count == 0 {
 			continue
 		}
-		fmt.Printf("%d\t%s\n", cAndC.count, cAndC.cmd)
+		fmt.Fprintf(w, "%d\t%s\n", cAndC.count, cAndC.cmd)
 	}
+	return nil
 }
 
-func printProcStartsAndEnds(samples []sample) {
-	fmt.Fprintf(os.Stderr, "event\tpid\tsample\tcmd\n")
+func printProcStartsAndEnds(w io.Writer, samples []sample, opts reportOptions) error {
+	fmt.Fprintf(w, "event\tpid\tsample\tcmd\n")
 	row := func(event string, pid int, nthSample int, cmd string) {
-		fmt.Fprintf(os.Stderr, "%s\t%d\t%d\t%s\n", event, pid, nthSample, cmd)
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", event, pid, nthSample, cmd)
 	}
 
 	procs := make(map[int]proc)
@@ -235,18 +933,50 @@ func printProcStartsAndEnds(samples []sample) {
 			}
 		}
 	}
+	return nil
 }
 
-func startCommandInBackground(name string, args []string, afterCommand func()) (*exec.Cmd, error) {
+// startCommandInBackground starts the command, after passing it to prepare if
+// that's set, and calls afterCommand once it has exited.
+func startCommandInBackground(name string, args []string, prepare func(cmd *exec.Cmd) error, output *outputRecorder, afterCommand func(exitCode int)) (*exec.Cmd, error) {
 	cmd := exec.Command(name, args...)
+	setProcessGroup(cmd)
+	if prepare != nil {
+		if err := prepare(cmd); err != nil {
+			return nil, err
+		}
+	}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	var pipes []*outputPipe
+	if output != nil {
+		stdout, err := output.pipe("stdout", os.Stdout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start command: %s", err)
+		}
+		stderr, err := output.pipe("stderr", os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start command: %s", err)
+		}
+		cmd.Stdout, cmd.Stderr = stdout.w, stderr.w
+		pipes = []*outputPipe{stdout, stderr}
+	}
 	log.Println("start of output from command:")
 	err := cmd.Start()
+	for _, p := range pipes {
+		// the command has its own copies of the write ends
+		p.w.Close()
+	}
 	go func() {
 		cmd.Wait()
+		// anything the command left running may still hold the pipes
+		// open, so don't wait for them to be closed
+		deadline := time.Now().Add(outputDrainTimeout)
+		for _, p := range pipes {
+			p.close(deadline)
+		}
 		log.Println("end of output from command")
-		afterCommand()
+		afterCommand(exitCodeOf(cmd.ProcessState))
 	}()
 	if err != nil {
 		return nil, fmt.Errorf("failed to start command: %s", err)
@@ -254,10 +984,14 @@ func startCommandInBackground(name string, args []string, afterCommand func()) (
 	return cmd, nil
 }
 
-func strictAtoi(s string) int {
-	i, err := strconv.Atoi(s)
-	if err != nil {
-		panic(err)
+// exitCodeOf returns the code that a shell would report for a finished
+// process, i.e. 128+n if it was killed by signal n.
+func exitCodeOf(state *os.ProcessState) int {
+	if state == nil {
+		return 1
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
 	}
-	return i
+	return state.ExitCode()
 }
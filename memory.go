@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// printMemoryPeak reports the most memory the whole process tree used at
+// once, as the sum of the resident set sizes of its processes, and which
+// processes made it up. Pages shared between processes are counted once for
+// each of them, so this overestimates what the tree needs when they share a
+// lot.
+func printMemoryPeak(w io.Writer, samples []sample, opts reportOptions) error {
+	var peak int64
+	peakAt := -1
+	for i, s := range samples {
+		var total int64
+		for _, p := range s.Procs {
+			total += p.RSS
+		}
+		if total > peak {
+			peak, peakAt = total, i
+		}
+	}
+	if peakAt < 0 {
+		fmt.Fprintln(w, "no memory usage was sampled (use a backend that reports RSS, such as ps)")
+		return nil
+	}
+	s := samples[peakAt]
+
+	procs := make([]proc, 0, len(s.Procs))
+	for _, p := range s.Procs {
+		procs = append(procs, p)
+	}
+	sort.Slice(procs, func(i, j int) bool {
+		if procs[i].RSS != procs[j].RSS {
+			return procs[i].RSS > procs[j].RSS
+		}
+		return procs[i].Pid < procs[j].Pid
+	})
+
+	fmt.Fprintf(w, "peak of %d bytes across %d processes at %s (+%s, sample %d)\n",
+		peak, len(procs), s.At.Format(time.RFC3339Nano), s.At.Sub(samples[0].At).Round(time.Millisecond), peakAt)
+	fmt.Fprintln(w, "rss_bytes\tshare\tpid\tcommand")
+	for _, p := range procs {
+		fmt.Fprintf(w, "%d\t%.1f%%\t%d\t%s\n", p.RSS, 100*float64(p.RSS)/float64(peak), p.Pid, p.Command)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// printProcTreeAsMermaid writes the observed process tree as a Mermaid
+// flowchart inside a fenced code block, which GitHub and most Markdown
+// renderers display inline.
+func printProcTreeAsMermaid(w io.Writer, samples []sample, opts reportOptions) error {
+	ls := lifetimes(samples)
+	parents := parentLifetimes(ls)
+
+	fmt.Fprintln(w, "```mermaid")
+	fmt.Fprintln(w, "flowchart TD")
+	for i, l := range ls {
+		fmt.Fprintf(w, "  p%d[\"%s<br/>pid %d, %d samples, %s\"]\n", i, mermaidEscaper.Replace(l.proc.Command), l.proc.Pid, l.samples(), l.duration().Round(time.Millisecond))
+	}
+	for i, parent := range parents {
+		if parent >= 0 {
+			fmt.Fprintf(w, "  p%d --> p%d\n", parent, i)
+		}
+	}
+	fmt.Fprintln(w, "```")
+	return nil
+}
+
+// mermaidEscaper replaces the characters that would end a quoted Mermaid
+// label or be read as HTML with entity codes.
+var mermaidEscaper = strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;", "\n", " ", "`", "#96;")
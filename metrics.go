@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metrics exposes the latest state of sampling in the Prometheus text format,
+// for -metrics-addr.
+type metrics struct {
+	normalize *regexp.Regexp
+
+	mu       sync.Mutex
+	live     int
+	commands map[string]int // normalized command -> live processes
+	seen     map[int]string // pid -> command of every process seen
+	total    int
+	samples  int
+	busy     time.Duration
+	lastBusy time.Duration
+}
+
+// serveMetrics starts serving /metrics on addr.
+func serveMetrics(addr string, normalize *regexp.Regexp) (*metrics, error) {
+	m := &metrics{normalize: normalize, seen: make(map[int]string)}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not serve metrics: %s", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go func() {
+		log.Println(http.Serve(l, mux))
+	}()
+	log.Printf("serving metrics on http://%s/metrics\n", l.Addr())
+	return m, nil
+}
+
+// record updates the metrics with a sample that took busy to capture.
+func (m *metrics) record(s sample, busy time.Duration) {
+	commands := make(map[string]int)
+	for _, p := range s.Procs {
+		commands[normalizeCommand(p.Command, m.normalize)]++
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.live = len(s.Procs)
+	m.commands = commands
+	for pid, p := range s.Procs {
+		if command, ok := m.seen[pid]; !ok || command != p.Command {
+			m.seen[pid] = p.Command
+			m.total++
+		}
+	}
+	m.samples++
+	m.busy += busy
+	m.lastBusy = busy
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	metric("pstree_prof_live_processes", "gauge", "Processes in the latest sample.")
+	fmt.Fprintf(w, "pstree_prof_live_processes %d\n", m.live)
+	metric("pstree_prof_processes_seen_total", "counter", "Distinct processes seen so far.")
+	fmt.Fprintf(w, "pstree_prof_processes_seen_total %d\n", m.total)
+	metric("pstree_prof_command_live_processes", "gauge", "Processes in the latest sample, by normalized command.")
+	commands := make([]string, 0, len(m.commands))
+	for command := range m.commands {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	for _, command := range commands {
+		fmt.Fprintf(w, "pstree_prof_command_live_processes{command=\"%s\"} %d\n", labelEscaper.Replace(command), m.commands[command])
+	}
+	metric("pstree_prof_samples_total", "counter", "Samples taken so far.")
+	fmt.Fprintf(w, "pstree_prof_samples_total %d\n", m.samples)
+	metric("pstree_prof_sample_seconds_total", "counter", "Time spent capturing samples.")
+	fmt.Fprintf(w, "pstree_prof_sample_seconds_total %g\n", m.busy.Seconds())
+	metric("pstree_prof_last_sample_seconds", "gauge", "How long the latest sample took to capture.")
+	fmt.Fprintf(w, "pstree_prof_last_sample_seconds %g\n", m.lastBusy.Seconds())
+}
+
+// labelEscaper escapes a Prometheus label value.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
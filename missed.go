@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// printMissedEstimate estimates how many short-lived processes the sampling
+// interval was too coarse to see, using two signals:
+//
+//   - parents that were never sampled: a sampled process whose parent wasn't
+//     seen in any sample must have been spawned by something that came and
+//     went between samples
+//   - pid gaps: the pids allocated between two consecutive new processes
+//     that were never seen. This is an upper bound, since other processes on
+//     the machine (and `ps` itself) also consume pids
+//
+// If events were recorded the processes that were actually missed are known,
+// and are reported too.
+func printMissedEstimate(w io.Writer, samples []sample, opts reportOptions) error {
+	if len(samples) == 0 {
+		fmt.Fprintln(w, "no samples")
+		return nil
+	}
+
+	seen := make(map[int]bool)
+	for _, s := range samples {
+		for pid := range s.Procs {
+			seen[pid] = true
+		}
+	}
+
+	// the root's parent (pstree_prof itself) and init, which adopts
+	// orphans, are expected to be missing
+	ls := lifetimes(samples)
+	expected := map[int]bool{0: true, 1: true}
+	for _, l := range ls {
+		if l.first == ls[0].first {
+			expected[l.proc.Ppid] = true
+		}
+	}
+	unseenParents := make(map[int]bool)
+	for _, s := range samples {
+		for _, p := range s.Procs {
+			if !seen[p.Ppid] && !expected[p.Ppid] {
+				unseenParents[p.Ppid] = true
+			}
+		}
+	}
+
+	gaps := 0
+	var lastNew []int
+	for i, s := range samples {
+		var newPids []int
+		for pid := range s.Procs {
+			if i == 0 || !containsPid(samples[i-1].Procs, pid) {
+				newPids = append(newPids, pid)
+			}
+		}
+		sort.Ints(newPids)
+		if len(newPids) > 0 {
+			if len(lastNew) > 0 {
+				if gap := newPids[0] - lastNew[len(lastNew)-1] - 1; gap > 0 && gap < 1000 {
+					// bigger gaps are more likely to be pid wraparound or
+					// unrelated activity
+					gaps += gap
+				}
+			}
+			for j := 1; j < len(newPids); j++ {
+				if gap := newPids[j] - newPids[j-1] - 1; gap > 0 && gap < 1000 {
+					gaps += gap
+				}
+			}
+			lastNew = newPids
+		}
+	}
+
+	sampledCount, fromEvents := 0, 0
+	var shortest time.Duration
+	for _, l := range ls {
+		if l.samples() == 0 {
+			fromEvents++
+			continue
+		}
+		sampledCount++
+		if d := l.duration(); d > 0 && (shortest == 0 || d < shortest) {
+			shortest = d
+		}
+	}
+
+	durations := sampleDurations(samples)
+	interval := time.Duration(0)
+	if len(durations) > 0 {
+		sorted := append([]time.Duration(nil), durations...)
+		interval = percentile(sorted, 0.5)
+	}
+
+	fmt.Fprintf(w, "sampled processes:\t%d\n", sampledCount)
+	fmt.Fprintf(w, "median interval:\t%s\n", interval.Round(time.Microsecond))
+	fmt.Fprintf(w, "unsampled parents:\t%d (spawned sampled processes but were never seen themselves)\n", len(unseenParents))
+	fmt.Fprintf(w, "unseen pids in gaps:\t%d (upper bound, includes other processes on the machine)\n", gaps)
+	if fromEvents > 0 {
+		fmt.Fprintf(w, "missed, from events:\t%d\n", fromEvents)
+	}
+
+	missed := len(unseenParents)
+	if fromEvents > missed {
+		missed = fromEvents
+	}
+	switch {
+	case missed == 0 && gaps == 0:
+		fmt.Fprintln(w, "suggestion:\tno processes appear to have been missed at this interval")
+	case interval > 0:
+		suggested := interval / 2
+		if shortest > 0 && shortest/2 < suggested {
+			suggested = shortest / 2
+		}
+		fmt.Fprintf(w, "suggestion:\ttry -interval %s to see more short-lived processes, or -events to see all of them\n", suggested.Round(time.Microsecond))
+	}
+	return nil
+}
+
+func containsPid(procs map[int]proc, pid int) bool {
+	_, ok := procs[pid]
+	return ok
+}
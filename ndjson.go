@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// writeSamplesAsNDJSON records the raw samples, one JSON object per line, so
+// they can be analyzed later (e.g. with `pstree_prof diff`).
+func writeSamplesAsNDJSON(w io.Writer, samples []sample, opts reportOptions) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, s := range samples {
+		if err := enc.Encode(s); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// readSamples loads samples recorded with -fmt ndjson.
+func readSamples(path string) ([]sample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var samples []sample
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var s sample
+		if err := dec.Decode(&s); err == io.EOF {
+			return samples, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: sample %d: %s", path, len(samples), err)
+		}
+		samples = append(samples, s)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// outputLine is a line written by the command, recorded with -tee.
+type outputLine struct {
+	At time.Time `json:"at"`
+	// Stream is stdout or stderr
+	Stream string `json:"stream"`
+	Text   string `json:"text"`
+	// Live is the number of processes in the sample before the line
+	Live int `json:"live"`
+}
+
+// outputRecorder timestamps the lines the command writes until they are
+// drained into a sample.
+type outputRecorder struct {
+	mu      sync.Mutex
+	lines   []outputLine
+	live    int
+	partial map[string][]byte
+}
+
+func newOutputRecorder() *outputRecorder {
+	return &outputRecorder{partial: make(map[string][]byte)}
+}
+
+// writer returns a writer that passes everything through to w and records
+// each line as coming from stream.
+func (r *outputRecorder) writer(stream string, w io.Writer) io.Writer {
+	return &teeWriter{recorder: r, stream: stream, w: w}
+}
+
+// setLive records how many processes were in the latest sample.
+func (r *outputRecorder) setLive(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.live = n
+}
+
+// drain returns the lines written since the last call. If final is set, any
+// unterminated lines are included too.
+func (r *outputRecorder) drain(final bool) []outputLine {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if final {
+		for _, stream := range []string{"stdout", "stderr"} {
+			if len(r.partial[stream]) > 0 {
+				r.record(stream, r.partial[stream])
+				delete(r.partial, stream)
+			}
+		}
+	}
+	lines := r.lines
+	r.lines = nil
+	return lines
+}
+
+func (r *outputRecorder) record(stream string, text []byte) {
+	r.lines = append(r.lines, outputLine{
+		At:     time.Now(),
+		Stream: stream,
+		Text:   string(text),
+		Live:   r.live,
+	})
+}
+
+// outputPipe carries one of the command's streams to the recorder. The
+// command is given the write end itself, rather than a writer for exec to
+// copy to, so that waiting for it to exit doesn't also wait for any
+// descendants still holding the pipe open.
+type outputPipe struct {
+	r, w *os.File
+	done chan struct{}
+}
+
+// outputDrainTimeout is how long the output of a command that has exited is
+// still copied for, to pick up what was buffered in the pipe.
+const outputDrainTimeout = 100 * time.Millisecond
+
+// pipe returns a pipe whose write end is for the command's stream, copying
+// what is written to it through to w and recording it.
+func (r *outputRecorder) pipe(stream string, w io.Writer) (*outputPipe, error) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	p := &outputPipe{r: pr, w: pw, done: make(chan struct{})}
+	go func() {
+		io.Copy(r.writer(stream, w), pr)
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// close stops copying at deadline, or as soon as the pipe is closed by
+// everything writing to it if that's sooner.
+func (p *outputPipe) close(deadline time.Time) {
+	if err := p.r.SetReadDeadline(deadline); err != nil {
+		// pipes can't time out on some platforms, so just stop
+		p.r.Close()
+		return
+	}
+	<-p.done
+	p.r.Close()
+}
+
+type teeWriter struct {
+	recorder *outputRecorder
+	stream   string
+	w        io.Writer
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+
+	r := t.recorder
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	buf := append(r.partial[t.stream], p[:n]...)
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		r.record(t.stream, bytes.TrimSuffix(buf[:i], []byte("\r")))
+		buf = buf[i+1:]
+	}
+	r.partial[t.stream] = append([]byte(nil), buf...)
+	return n, err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// printPeakTree prints the process tree at the sample with the most live
+// processes, like `pstree -p`, with how long each process had been running
+// at that moment.
+func printPeakTree(w io.Writer, samples []sample, opts reportOptions) error {
+	peak, peakAt := 0, -1
+	for i, s := range samples {
+		if len(s.Procs) > peak {
+			peak, peakAt = len(s.Procs), i
+		}
+	}
+	if peakAt < 0 {
+		fmt.Fprintln(w, "no processes were sampled")
+		return nil
+	}
+	s := samples[peakAt]
+
+	ages := make(map[int]time.Duration, peak)
+	for _, l := range lifetimes(samples) {
+		if l.first <= peakAt && peakAt <= l.last {
+			ages[l.proc.Pid] = s.At.Sub(l.start)
+		}
+	}
+
+	var roots []int
+	children := make(map[int][]int)
+	for pid, p := range s.Procs {
+		if _, ok := s.Procs[p.Ppid]; ok && p.Ppid != pid {
+			children[p.Ppid] = append(children[p.Ppid], pid)
+		} else {
+			roots = append(roots, pid)
+		}
+	}
+	sort.Ints(roots)
+	for _, c := range children {
+		sort.Ints(c)
+	}
+
+	fmt.Fprintf(w, "peak of %d live processes at %s (+%s, sample %d)\n",
+		peak, s.At.Format(time.RFC3339Nano), s.At.Sub(samples[0].At).Round(time.Millisecond), peakAt)
+	var printTree func(pid int, prefix, branch, indent string)
+	printTree = func(pid int, prefix, branch, indent string) {
+		p := s.Procs[pid]
+		fmt.Fprintf(w, "%s%s%d %s (up %s)\n", prefix, branch, pid, p.Command, ages[pid].Round(time.Millisecond))
+		for i, child := range children[pid] {
+			if i == len(children[pid])-1 {
+				printTree(child, prefix+indent, "`-- ", "    ")
+			} else {
+				printTree(child, prefix+indent, "|-- ", "|   ")
+			}
+		}
+	}
+	for _, pid := range roots {
+		printTree(pid, "", "", "")
+	}
+	return nil
+}
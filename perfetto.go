@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers and enum values from Perfetto's trace_packet.proto,
+// track_descriptor.proto and track_event.proto.
+const (
+	perfettoTracePacket = 1 // Trace.packet
+
+	perfettoTimestamp     = 8  // TracePacket.timestamp
+	perfettoSequenceID    = 10 // TracePacket.trusted_packet_sequence_id
+	perfettoTrackEvent    = 11 // TracePacket.track_event
+	perfettoSequenceFlags = 13 // TracePacket.sequence_flags
+	perfettoTrackDesc     = 60 // TracePacket.track_descriptor
+
+	perfettoTrackUUID   = 1 // TrackDescriptor.uuid
+	perfettoTrackName   = 2 // TrackDescriptor.name
+	perfettoTrackParent = 5 // TrackDescriptor.parent_uuid
+
+	perfettoEventType       = 9  // TrackEvent.type
+	perfettoEventTrackUUID  = 11 // TrackEvent.track_uuid
+	perfettoEventCategories = 22 // TrackEvent.categories
+	perfettoEventName       = 23 // TrackEvent.name
+
+	perfettoSliceBegin = 1
+	perfettoSliceEnd   = 2
+	perfettoInstant    = 3
+
+	perfettoIncrementalStateCleared = 1
+	perfettoNeedsIncrementalState   = 2
+)
+
+// exportSamplesAsPerfetto writes a Perfetto protobuf trace, which
+// ui.perfetto.dev opens directly. Every process lifetime gets a track named
+// by its command, nested under the track of its parent, with a slice
+// covering the time it was running. Output lines recorded with -tee, and the
+// -timeout, are instant events on a track for the whole run.
+func exportSamplesAsPerfetto(w io.Writer, samples []sample, opts reportOptions) error {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	var trace []byte
+	packet := func(fields []byte) {
+		trace = protowire.AppendTag(trace, perfettoTracePacket, protowire.BytesType)
+		trace = protowire.AppendBytes(trace, fields)
+	}
+	track := func(uuid, parent uint64, name string, first bool) {
+		var desc []byte
+		desc = appendVarintField(desc, perfettoTrackUUID, uuid)
+		desc = appendStringField(desc, perfettoTrackName, name)
+		if parent != 0 {
+			desc = appendVarintField(desc, perfettoTrackParent, parent)
+		}
+		var p []byte
+		p = appendVarintField(p, perfettoSequenceID, 1)
+		if first {
+			p = appendVarintField(p, perfettoSequenceFlags, perfettoIncrementalStateCleared)
+		}
+		p = protowire.AppendTag(p, perfettoTrackDesc, protowire.BytesType)
+		p = protowire.AppendBytes(p, desc)
+		packet(p)
+	}
+
+	type trackEvent struct {
+		at    int64
+		kind  uint64
+		track uint64
+		name  string
+	}
+	var events []trackEvent
+	event := func(at int64, kind, track uint64, name string) {
+		events = append(events, trackEvent{at: at, kind: kind, track: track, name: name})
+	}
+
+	// the run is track 1, and process lifetime i is track i+2
+	const runTrack = 1
+	track(runTrack, 0, NAME, true)
+	event(samples[0].At.UnixNano(), perfettoSliceBegin, runTrack, "run")
+	event(samples[len(samples)-1].At.UnixNano(), perfettoSliceEnd, runTrack, "")
+	if i, ok := firstTimedOut(samples); ok {
+		event(samples[i].At.UnixNano(), perfettoInstant, runTrack, "timed out")
+	}
+	for _, s := range samples {
+		for _, line := range s.Output {
+			event(line.At.UnixNano(), perfettoInstant, runTrack, line.Stream+": "+line.Text)
+		}
+	}
+
+	ls := lifetimes(samples)
+	parents := parentLifetimes(ls)
+	for i, l := range ls {
+		uuid, parent := uint64(i+2), uint64(runTrack)
+		if parents[i] >= 0 {
+			parent = uint64(parents[i] + 2)
+		}
+		track(uuid, parent, fmt.Sprintf("%s (%d)", l.proc.Command, l.proc.Pid), false)
+		event(l.start.UnixNano(), perfettoSliceBegin, uuid, l.proc.Command)
+		event(l.end.UnixNano(), perfettoSliceEnd, uuid, "")
+	}
+
+	// each track only has one slice, so events at the same time can be in
+	// any order as long as a slice's end stays after its beginning
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].at < events[j].at
+	})
+	for _, e := range events {
+		var te []byte
+		te = appendVarintField(te, perfettoEventType, e.kind)
+		te = appendVarintField(te, perfettoEventTrackUUID, e.track)
+		if e.kind != perfettoSliceEnd {
+			te = appendStringField(te, perfettoEventCategories, "process")
+			te = appendStringField(te, perfettoEventName, e.name)
+		}
+		var p []byte
+		p = appendVarintField(p, perfettoTimestamp, uint64(e.at))
+		p = appendVarintField(p, perfettoSequenceID, 1)
+		p = appendVarintField(p, perfettoSequenceFlags, perfettoNeedsIncrementalState)
+		p = protowire.AppendTag(p, perfettoTrackEvent, protowire.BytesType)
+		p = protowire.AppendBytes(p, te)
+		packet(p)
+	}
+
+	_, err := w.Write(trace)
+	return err
+}
+
+func appendVarintField(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendStringField(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// exportSamplesAsPprof writes a gzipped pprof profile in which each process
+// is a "function" and the stack of a sample is the process's ancestry, so
+// `go tool pprof -http` shows the process tree as a flame graph. Every
+// process in a sample contributes the number of samples it stands for and the
+// wall time that it represents.
+func exportSamplesAsPprof(w io.Writer, samples []sample, opts reportOptions) error {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "wall", Unit: "nanoseconds"},
+		},
+		PeriodType: &profile.ValueType{Type: "wall", Unit: "nanoseconds"},
+	}
+	if len(samples) > 0 {
+		p.TimeNanos = samples[0].At.UnixNano()
+		p.DurationNanos = samples[len(samples)-1].At.Sub(samples[0].At).Nanoseconds()
+	}
+
+	locations := make(map[string]*profile.Location)
+	location := func(command string) *profile.Location {
+		if loc, ok := locations[command]; ok {
+			return loc
+		}
+		fn := &profile.Function{
+			ID:         uint64(len(p.Function) + 1),
+			Name:       command,
+			SystemName: command,
+		}
+		p.Function = append(p.Function, fn)
+		loc := &profile.Location{
+			ID:   uint64(len(p.Location) + 1),
+			Line: []profile.Line{{Function: fn}},
+		}
+		p.Location = append(p.Location, loc)
+		locations[command] = loc
+		return loc
+	}
+
+	type stackKey struct {
+		pid   int
+		stack string
+	}
+	stacks := make(map[stackKey]*profile.Sample)
+	durations := sampleDurations(samples)
+	for i, s := range samples {
+		for pid := range s.Procs {
+			chain := ancestry(s.Procs, pid)
+			commands := make([]string, len(chain))
+			for j, ancestor := range chain {
+				commands[j] = ancestor.Command
+			}
+			key := stackKey{pid: pid, stack: strings.Join(commands, "\x00")}
+
+			ps, ok := stacks[key]
+			if !ok {
+				ps = &profile.Sample{
+					Value:    []int64{0, 0},
+					NumLabel: map[string][]int64{"pid": {int64(pid)}},
+				}
+				for _, command := range commands {
+					ps.Location = append(ps.Location, location(command))
+				}
+				stacks[key] = ps
+				p.Sample = append(p.Sample, ps)
+			}
+			ps.Value[0] += int64(s.weight())
+			ps.Value[1] += durations[i].Nanoseconds()
+		}
+	}
+	// a sample merged by downsampling stands for several sampling periods
+	periods := -1
+	for _, s := range samples {
+		periods += s.weight()
+	}
+	if periods > 0 {
+		p.Period = p.DurationNanos / int64(periods)
+	}
+
+	if err := p.CheckValid(); err != nil {
+		return fmt.Errorf("generated an invalid profile: %s", err)
+	}
+	return p.Write(w)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// psLister lists processes by parsing the output of `ps`, which works on
+// Linux, macOS and the BSDs.
+type psLister struct {
+	// threads requests the thread count of each process, which only the
+	// procps implementation of `ps` supports
+	threads bool
+	// extra are additional `ps -o` fields to capture into proc.Extra
+	extra []string
+	// strict returns an error for unparsable lines rather than skipping them
+	strict bool
+}
+
+func (l psLister) withPsOptions(opts psOptions) procLister {
+	l.extra = opts.columns
+	l.strict = opts.strict
+	return l
+}
+
+func (l psLister) listProcs() (map[int]proc, error) {
+	cols := []string{"user", "pid", "ppid", "pgid", "stat", "rss"}
+	if l.threads {
+		cols = append(cols, "thcount")
+	}
+	cols = append(cols, l.extra...)
+	// command must come last, since it may contain spaces
+	cols = append(cols, "command")
+	args := []string{"ps", "-axwwo", strings.Join(cols, ",")}
+	psCmd := exec.Command(args[0], args[1:]...)
+	psOut, err := psCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not start `ps`: %s", err)
+	}
+
+	procs, skipped, err := parsePsOutput(string(psOut), cols, l.strict)
+	if err != nil {
+		return nil, err
+	}
+	if len(skipped) > 0 {
+		// the same lines are usually skipped in every sample, so only
+		// say so once
+		warnSkippedOnce.Do(func() {
+			log.Printf("skipped %d unparsable lines of `ps` output (not reported again), the first being: %s\n", len(skipped), skipped[0])
+		})
+	}
+	// not interested in the `ps ...` command that we started
+	delete(procs, psCmd.Process.Pid)
+	return procs, nil
+}
+
+// warnSkippedOnce guards the warning about unparsable lines of `ps` output.
+var warnSkippedOnce sync.Once
+
+// parsePsOutput parses the output of `ps -o` with the given columns, header
+// included. Unless strict, lines that can't be parsed are skipped, and the
+// errors for them returned.
+func parsePsOutput(out string, cols []string, strict bool) (map[int]proc, []error, error) {
+	lines := strings.Split(out, "\n")
+	// skip header
+	lines = lines[1:]
+	// if last line is empty, skip
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	procs := make(map[int]proc)
+	var skipped []error
+	for _, line := range lines {
+		proc, err := parseLineAsProc(line, cols)
+		if err != nil {
+			if strict {
+				return nil, nil, err
+			}
+			skipped = append(skipped, err)
+			continue
+		}
+		procs[proc.Pid] = proc
+	}
+	return procs, skipped, nil
+}
+
+// parseLineAsProc parses one line of `ps` output with the given columns, all
+// but the last of which must not contain spaces.
+func parseLineAsProc(line string, cols []string) (proc, error) {
+	var colStart, col int
+	prevWasSpace := false
+	parsedCols := make([]string, len(cols))
+	for i, c := range line {
+		if col == len(cols)-1 {
+			// final column, don't need to search for the end
+			// abc___def___ghi
+			//    	       ^
+			// the padding after a left-aligned column such as tty
+			// isn't part of it
+			parsedCols[col] = strings.TrimLeft(line[i:], " ")
+			break
+		}
+
+		if !prevWasSpace && c == ' ' {
+			// first space char after a string of non-spaces, i.e. the start of the column padding
+			// abc___def___ghi
+			//    ^
+			parsedCols[col] = line[colStart:i]
+			col += 1
+			prevWasSpace = true
+		} else if prevWasSpace && c != ' ' {
+			// first non-space after a string of spaces, i.e. the start of a new column
+			// abc___def___ghi
+			//       ^
+			colStart = i
+			prevWasSpace = false
+		}
+	}
+	if col < len(cols)-1 {
+		return proc{}, fmt.Errorf("expected %d columns, found %d: %q", len(cols), col, line)
+	}
+
+	values := make(map[string]string, len(cols))
+	for i, col := range cols {
+		values[col] = parsedCols[i]
+	}
+	p := proc{
+		User:    values["user"],
+		Command: values["command"],
+	}
+	for col, value := range values {
+		var err error
+		switch col {
+		case "user", "command":
+		case "pid":
+			p.Pid, err = parseInt(col, value, line)
+		case "ppid":
+			p.Ppid, err = parseInt(col, value, line)
+		case "pgid":
+			p.Pgid, err = parseInt(col, value, line)
+		case "stat":
+			p.Zombie = strings.HasPrefix(value, "Z")
+		case "rss":
+			// in KiB
+			var kib int
+			kib, err = parseInt(col, value, line)
+			p.RSS = int64(kib) * 1024
+		case "thcount":
+			p.Threads, err = parseInt(col, value, line)
+		default:
+			if p.Extra == nil {
+				p.Extra = make(map[string]string)
+			}
+			p.Extra[col] = value
+		}
+		if err != nil {
+			return proc{}, err
+		}
+	}
+	return p, nil
+}
+
+func parseInt(col, value, line string) (int, error) {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %q", col, value, line)
+	}
+	return i, nil
+}
+
+// parseColumns validates a -columns value, rejecting the fields that are
+// always captured and anything that would confuse `ps -o`.
+func parseColumns(value string) ([]string, error) {
+	columns := splitList(value)
+	for _, col := range columns {
+		switch col {
+		case "user", "pid", "ppid", "pgid", "stat", "rss", "command", "thcount":
+			return nil, fmt.Errorf("-columns: %s is always captured", col)
+		}
+		if strings.ContainsAny(col, "= ") {
+			return nil, fmt.Errorf("-columns: invalid field %q", col)
+		}
+	}
+	return columns, nil
+}
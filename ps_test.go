@@ -0,0 +1,201 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	procpsCols = []string{"user", "pid", "ppid", "pgid", "stat", "rss", "thcount", "command"}
+	bsdCols    = []string{"user", "pid", "ppid", "pgid", "stat", "rss", "command"}
+)
+
+// procpsOutput is `ps -axwwo user,pid,ppid,pgid,stat,rss,thcount,command` as
+// printed by procps-ng on Linux, which truncates long user names with a +.
+const procpsOutput = `USER       PID  PPID  PGID STAT   RSS THCNT COMMAND
+root         1     0     0 SLl   9080     6 /sbin/init splash
+root         2     0     0 S        0     1 [kthreadd]
+root         4     2     0 I<       0     1 [kworker/R-rcu_gp]
+systemd+   612     1   612 Ssl   7424     2 /lib/systemd/systemd-timesyncd
+chris    40231 40229 40231 Ss    5632     1 -bash
+chris    40298 40231 40298 Z        0     1 [make] <defunct>
+`
+
+// bsdOutput is `ps -axwwo user,pid,ppid,pgid,stat,rss,command` as printed on
+// macOS, which doesn't truncate user names.
+const bsdOutput = `USER               PID  PPID  PGID STAT    RSS COMMAND
+root                 1     0     1 Ss    12880 /sbin/launchd
+_windowserver      150     1   150 Ss   134416 /System/Library/PrivateFrameworks/SkyLight.framework/Resources/WindowServer -daemon
+christian        40231 40229 40231 S      5632 -zsh
+christian        40298 40231 40231 Z         0 <defunct>
+`
+
+func TestParseLineAsProc(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		cols    []string
+		want    proc
+		wantErr bool
+	}{
+		{
+			name: "procps",
+			line: "root         1     0     0 SLl   9080     6 /sbin/init splash",
+			cols: procpsCols,
+			want: proc{User: "root", Pid: 1, Ppid: 0, Pgid: 0, RSS: 9080 * 1024, Threads: 6, Command: "/sbin/init splash"},
+		},
+		{
+			name: "procps kernel thread",
+			line: "root         2     0     0 S        0     1 [kthreadd]",
+			cols: procpsCols,
+			want: proc{User: "root", Pid: 2, Threads: 1, Command: "[kthreadd]"},
+		},
+		{
+			name: "procps truncated user",
+			line: "systemd+   612     1   612 Ssl   7424     2 /lib/systemd/systemd-timesyncd",
+			cols: procpsCols,
+			want: proc{User: "systemd+", Pid: 612, Ppid: 1, Pgid: 612, RSS: 7424 * 1024, Threads: 2, Command: "/lib/systemd/systemd-timesyncd"},
+		},
+		{
+			name: "procps zombie",
+			line: "chris    40298 40231 40298 Z        0     1 [make] <defunct>",
+			cols: procpsCols,
+			want: proc{User: "chris", Pid: 40298, Ppid: 40231, Pgid: 40298, Zombie: true, Threads: 1, Command: "[make] <defunct>"},
+		},
+		{
+			name: "procps extra column",
+			line: "root         2     0     0 S        0     1 ?        [kthreadd]",
+			cols: []string{"user", "pid", "ppid", "pgid", "stat", "rss", "thcount", "tty", "command"},
+			want: proc{User: "root", Pid: 2, Threads: 1, Command: "[kthreadd]", Extra: map[string]string{"tty": "?"}},
+		},
+		{
+			name: "bsd",
+			line: "_windowserver      150     1   150 Ss   134416 /System/Library/PrivateFrameworks/SkyLight.framework/Resources/WindowServer -daemon",
+			cols: bsdCols,
+			want: proc{User: "_windowserver", Pid: 150, Ppid: 1, Pgid: 150, RSS: 134416 * 1024, Command: "/System/Library/PrivateFrameworks/SkyLight.framework/Resources/WindowServer -daemon"},
+		},
+		{
+			name: "bsd zombie",
+			line: "christian        40298 40231 40231 Z         0 <defunct>",
+			cols: bsdCols,
+			want: proc{User: "christian", Pid: 40298, Ppid: 40231, Pgid: 40231, Zombie: true, Command: "<defunct>"},
+		},
+		{
+			name:    "short line",
+			line:    "root         2     0",
+			cols:    procpsCols,
+			wantErr: true,
+		},
+		{
+			name:    "empty line",
+			line:    "",
+			cols:    bsdCols,
+			wantErr: true,
+		},
+		{
+			name:    "invalid pid",
+			line:    "root       abc     0     0 S        0     1 [kthreadd]",
+			cols:    procpsCols,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLineAsProc(tt.line, tt.cols)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePsOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		cols []string
+		pids []int
+	}{
+		{name: "procps", out: procpsOutput, cols: procpsCols, pids: []int{1, 2, 4, 612, 40231, 40298}},
+		{name: "bsd", out: bsdOutput, cols: bsdCols, pids: []int{1, 150, 40231, 40298}},
+		{name: "header only", out: "USER PID PPID PGID STAT RSS COMMAND\n", cols: bsdCols},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procs, skipped, err := parsePsOutput(tt.out, tt.cols, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(skipped) > 0 {
+				t.Errorf("skipped %v", skipped)
+			}
+			if len(procs) != len(tt.pids) {
+				t.Errorf("got %d procs, want %d", len(procs), len(tt.pids))
+			}
+			for _, pid := range tt.pids {
+				if procs[pid].Pid != pid {
+					t.Errorf("missing pid %d", pid)
+				}
+			}
+		})
+	}
+}
+
+func TestParsePsOutputStrict(t *testing.T) {
+	// a line cut short, as when a process exits while ps is printing it
+	lines := strings.Split(procpsOutput, "\n")
+	lines = append(lines[:3], append([]string{"root        99     2"}, lines[3:]...)...)
+	out := strings.Join(lines, "\n")
+
+	if _, _, err := parsePsOutput(out, procpsCols, true); err == nil {
+		t.Error("strict: expected an error for the short line")
+	}
+
+	procs, skipped, err := parsePsOutput(out, procpsCols, false)
+	if err != nil {
+		t.Fatalf("lenient: %s", err)
+	}
+	if len(skipped) != 1 {
+		t.Errorf("lenient: got %d skipped lines, want 1", len(skipped))
+	}
+	if _, ok := procs[99]; ok {
+		t.Error("lenient: the short line was parsed")
+	}
+	if len(procs) != 6 {
+		t.Errorf("lenient: got %d procs, want the other 6", len(procs))
+	}
+}
+
+func TestStillRunning(t *testing.T) {
+	lister, err := newProcLister("ps", psOptions{})
+	if err != nil {
+		t.Skip(err)
+	}
+	current, err := lister.listProcs()
+	if err != nil {
+		t.Skip(err)
+	}
+	self := current[os.Getpid()]
+	// a pid that has since been reused by another command
+	reused := self
+	reused.Command = "make"
+	gone := proc{Pid: -1, Command: "cc a.c"}
+
+	if got := stillRunning("ps", map[int]proc{self.Pid: self, gone.Pid: gone}); len(got) != 1 || got[self.Pid].Pid != self.Pid {
+		t.Errorf("got %v, want only the test process", got)
+	}
+	if got := stillRunning("ps", map[int]proc{reused.Pid: reused}); len(got) != 0 {
+		t.Errorf("got %v, want the reused pid left out", got)
+	}
+}
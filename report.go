@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// reportOptions holds the flags that tweak how individual reports are produced.
+type reportOptions struct {
+	normalize    *regexp.Regexp
+	otlpEndpoint string
+	otlpInsecure bool
+	// envKeys are the variables captured with -capture-env
+	envKeys []string
+	// columns are the extra fields captured with -columns
+	columns []string
+	// showAncestry labels processes by their chain of ancestors in the
+	// count and summary reports (-show-ancestry)
+	showAncestry bool
+	// shortLived is the most samples a process may be seen in to be
+	// reported by -fmt short_lived
+	shortLived int
+	// template is the parsed -template-file for -fmt template
+	template *template.Template
+}
+
+type outputFormat struct {
+	write func(w io.Writer, samples []sample, opts reportOptions) error
+	// stderr is set for formats that are written to stderr rather than stdout
+	// when no output path is given
+	stderr bool
+	// binary formats must be written to a file, since they would be
+	// interleaved with the command's output otherwise
+	binary bool
+	// stream formats have no write func, since their output is written as
+	// each sample is captured rather than at the end
+	stream bool
+	// remote formats send the samples elsewhere rather than writing a report
+	remote bool
+}
+
+var outputFormats = map[string]outputFormat{
+	"cgroup":          {write: printCgroupStats},
+	"chrome":          {write: exportSamplesAsChromeTrace},
+	"concurrency":     {write: printConcurrency},
+	"containers":      {write: printContainers},
+	"count":           {write: printProcCounts},
+	"cpu":             {write: printCPUUsage},
+	"dot":             {write: printProcTreeAsDot},
+	"csv":             {write: printLifetimesAsCSV},
+	"groups":          {write: printCommandGroups},
+	"shape":           {write: printTreeShape},
+	"short_lived":     {write: printShortLived},
+	"sparkline":       {write: printConcurrencySparkline},
+	"starts_and_ends": {write: printProcStartsAndEnds, stderr: true},
+	"stream":          {stream: true},
+	"summary":         {write: printSummary},
+	"svg":             {write: printLifetimesAsSVG},
+	"template":        {write: executeTemplate},
+	"threads":         {write: printThreadCounts},
+	"trace":           {write: exportSamplesAsTraces, stderr: true},
+	"tsv":             {write: printLifetimesAsTSV},
+	"io":              {write: printIOCounters},
+	"memory":          {write: printMemoryPeak},
+	"mermaid":         {write: printProcTreeAsMermaid},
+	"missed":          {write: printMissedEstimate},
+	"ndjson":          {write: writeSamplesAsNDJSON},
+	"peak":            {write: printPeakTree},
+	"perfetto":        {write: exportSamplesAsPerfetto, binary: true},
+	"otlp":            {write: exportSamplesOverOTLP, stderr: true, remote: true},
+	"pprof":           {write: exportSamplesAsPprof, binary: true},
+	"zombies":         {write: printZombies},
+}
+
+func formatNames() []string {
+	names := make([]string, 0, len(outputFormats))
+	for name := range outputFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseFormats splits a comma-separated -fmt value and checks that every
+// format is known.
+func parseFormats(spec string) ([]string, error) {
+	formats := splitList(spec)
+	for _, name := range formats {
+		if _, ok := outputFormats[name]; !ok {
+			return nil, fmt.Errorf("unrecognized output format %q (expected one of %s)", name, strings.Join(formatNames(), ", "))
+		}
+	}
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("at least one output format must be specified")
+	}
+	return formats, nil
+}
+
+// checkFormatDestinations rejects binary formats that have nowhere to go but
+// the terminal.
+func checkFormatDestinations(formats []string, out string) error {
+	if out != "" {
+		return nil
+	}
+	for _, name := range formats {
+		if outputFormats[name].binary {
+			return fmt.Errorf("-fmt %s produces binary output, so -o must be specified", name)
+		}
+	}
+	return nil
+}
+
+// reportPath returns where the report for format should be written, or "" if
+// it should go to the format's default stream. When several formats are
+// requested each one gets its own file, named by appending the format to out.
+func reportPath(out, format string, nFormats int) string {
+	if out == "" || nFormats == 1 {
+		return out
+	}
+	return out + "." + format
+}
+
+func writeReports(formats []string, out string, samples []sample, opts reportOptions) error {
+	for _, name := range formats {
+		if outputFormats[name].stream {
+			continue
+		}
+		if err := writeReport(name, reportPath(out, name, len(formats)), samples, opts); err != nil {
+			return fmt.Errorf("could not write %s report: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func writeReport(name, path string, samples []sample, opts reportOptions) error {
+	format := outputFormats[name]
+	if path == "" {
+		w := os.Stdout
+		if format.stderr {
+			w = os.Stderr
+		}
+		return format.write(w, samples, opts)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := format.write(f, samples, opts); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildSamples samples the scripted run of buildTables.
+func buildSamples(t *testing.T) []sample {
+	t.Helper()
+	return recordSamples(t, newFakeLister(buildTables()...), 100, nil)
+}
+
+// render writes the report on samples with the default options.
+func render(t *testing.T, write func(io.Writer, []sample, reportOptions) error, samples []sample) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := write(&buf, samples, reportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// procCounts runs the count report, whose rows are in no particular order
+// when counts tie, and returns the count of each command.
+func procCounts(t *testing.T, samples []sample) map[string]int {
+	t.Helper()
+	out := render(t, printProcCounts, samples)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if lines[0] != "count\tcommand" {
+		t.Fatalf("unexpected header %q", lines[0])
+	}
+	counts := make(map[string]int)
+	for _, line := range lines[1:] {
+		fields := strings.SplitN(line, "\t", 2)
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			t.Fatalf("unexpected row %q", line)
+		}
+		counts[fields[1]] = n
+	}
+	return counts
+}
+
+func TestPrintProcCounts(t *testing.T) {
+	want := map[string]int{
+		"make":         5,
+		"sh -c cc a.c": 2,
+		"cc a.c":       2,
+		"cc b.c":       2,
+		"sudo true":    1,
+		"true":         1,
+	}
+	if got := procCounts(t, buildSamples(t)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPrintProcCountsMerged(t *testing.T) {
+	samples := buildSamples(t)
+	// the second sample stands for three
+	samples[1].Merged = 3
+	got := procCounts(t, samples)
+	if got["make"] != 7 || got["cc a.c"] != 4 {
+		t.Errorf("got %v, want make counted 7 times and cc a.c 4", got)
+	}
+}
+
+func TestPrintSummary(t *testing.T) {
+	got := render(t, printSummary, buildSamples(t))
+	want := `invocations	total	mean	p95	max_concurrent	command
+2	40ms	20ms	20ms	2	cc
+1	40ms	40ms	40ms	1	make
+1	20ms	20ms	20ms	1	sh
+1	10ms	10ms	10ms	1	sudo
+1	10ms	10ms	10ms	1	true
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPrintSummaryAncestryChanges(t *testing.T) {
+	cc := proc{Pid: 102, Ppid: 101, Command: "cc a.c"}
+	orphaned := cc
+	orphaned.Ppid = 1
+	samples := []sample{
+		{At: sampleStart, Procs: map[int]proc{
+			100: {Pid: 100, Ppid: 1, Command: "make", Children: []int{101}},
+			101: {Pid: 101, Ppid: 100, Command: "sh -c cc a.c", Children: []int{102}},
+			102: cc,
+		}},
+		// make and sh have exited, and cc has been reparented
+		{At: sampleStart.Add(10 * time.Millisecond), Procs: map[int]proc{102: orphaned}},
+		{At: sampleStart.Add(20 * time.Millisecond), Procs: map[int]proc{102: orphaned}},
+	}
+	var buf bytes.Buffer
+	if err := printSummary(&buf, samples, reportOptions{showAncestry: true}); err != nil {
+		t.Fatal(err)
+	}
+	want := `invocations	total	mean	p95	max_concurrent	command
+1	20ms	20ms	20ms	1	make → sh → cc
+1	10ms	10ms	10ms	1	make
+1	10ms	10ms	10ms	1	make → sh
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPrintConcurrency(t *testing.T) {
+	got := render(t, printConcurrency, buildSamples(t))
+	want := `sample,elapsed_seconds,at,live
+0,0.000000,2024-01-02T03:04:05Z,1
+1,0.010000,2024-01-02T03:04:05.01Z,3
+2,0.020000,2024-01-02T03:04:05.02Z,4
+3,0.030000,2024-01-02T03:04:05.03Z,4
+4,0.040000,2024-01-02T03:04:05.04Z,1
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCollapseWrappers(t *testing.T) {
+	samples := buildSamples(t)
+	samples[2].Merged = 2
+	collapsed := collapseWrappers(samples, parseWrappers(defaultWrappers))
+
+	s := collapsed[2]
+	if _, ok := s.Procs[101]; ok {
+		t.Error("sh -c wasn't collapsed")
+	}
+	if got := s.Procs[102].Ppid; got != 100 {
+		t.Errorf("got ppid %d for cc a.c, want it reparented to make (100)", got)
+	}
+	children := s.Procs[100].Children
+	sort.Ints(children)
+	if !reflect.DeepEqual(children, []int{102, 103}) {
+		t.Errorf("got children %v of make, want [102 103]", children)
+	}
+	if s.Merged != 2 {
+		t.Errorf("got Merged %d, want 2", s.Merged)
+	}
+	// sudo isn't a wrapper, so is left alone
+	if _, ok := collapsed[3].Procs[104]; !ok {
+		t.Error("sudo was collapsed")
+	}
+	// the samples passed in are left alone
+	if _, ok := samples[2].Procs[101]; !ok {
+		t.Error("sh -c was removed from the original samples")
+	}
+}
+
+func TestCollapseWrappersBeforeAndAfterChildren(t *testing.T) {
+	makeProc := proc{Pid: 100, Ppid: 1, Command: "make"}
+	sh := proc{Pid: 101, Ppid: 100, Command: "sh -c cc a.c"}
+	cc := proc{Pid: 102, Ppid: 101, Command: "cc a.c"}
+	// sh is seen before it forks cc and again after cc exits
+	lister := newFakeLister(
+		map[int]proc{100: makeProc, 101: sh},
+		map[int]proc{100: makeProc, 101: sh, 102: cc},
+		map[int]proc{100: makeProc, 101: sh},
+	)
+	samples := collapseWrappers(recordSamples(t, lister, 100, nil), parseWrappers(defaultWrappers))
+	for i, s := range samples {
+		if _, ok := s.Procs[101]; ok {
+			t.Errorf("sample %d: sh -c wasn't collapsed", i)
+		}
+	}
+	got := render(t, printSummary, samples)
+	want := `invocations	total	mean	p95	max_concurrent	command
+1	20ms	20ms	20ms	1	make
+1	10ms	10ms	10ms	1	cc
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFilterSamples(t *testing.T) {
+	samples := buildSamples(t)
+	samples[2].Merged = 2
+	samples[3].TimedOut = true
+	samples[3].Output = []outputLine{{Text: "done"}}
+	filtered := filterSamples(samples, nil, regexp.MustCompile(`^sh `))
+
+	for i, s := range filtered {
+		if _, ok := s.Procs[101]; ok {
+			t.Errorf("sample %d: sh -c wasn't excluded", i)
+		}
+	}
+	if got := filtered[2].Procs[100].Children; !reflect.DeepEqual(got, []int{103}) {
+		t.Errorf("got children %v of make, want only the kept [103]", got)
+	}
+	if filtered[2].Merged != 2 {
+		t.Errorf("got Merged %d, want 2", filtered[2].Merged)
+	}
+	if !filtered[3].TimedOut || len(filtered[3].Output) != 1 {
+		t.Errorf("lost TimedOut or Output: %+v", filtered[3])
+	}
+	if got := procCounts(t, filtered)["make"]; got != 6 {
+		t.Errorf("got make counted %d times, want 6", got)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// printRunStats compares the runs of -runs, reporting the mean and standard
+// deviation of each normalized command's invocations per run and of the
+// lifetimes of those invocations. A command missing from a run counts as 0
+// invocations in it.
+func printRunStats(w io.Writer, runs [][]sample, opts reportOptions) error {
+	type commandStats struct {
+		command     string
+		invocations []float64 // per run
+		lifetimes   []float64 // seconds, per invocation across all runs
+		seen        int       // runs it appeared in
+		total       float64   // seconds, across all runs
+	}
+	stats := make(map[string]*commandStats)
+	walls := make([]float64, len(runs))
+	for run, samples := range runs {
+		if len(samples) > 0 {
+			walls[run] = samples[len(samples)-1].At.Sub(samples[0].At).Seconds()
+		}
+		for _, l := range lifetimes(samples) {
+			key := normalizeCommand(l.proc.Command, opts.normalize)
+			st, ok := stats[key]
+			if !ok {
+				st = &commandStats{command: key, invocations: make([]float64, len(runs))}
+				stats[key] = st
+			}
+			if st.invocations[run] == 0 {
+				st.seen++
+			}
+			st.invocations[run]++
+			st.lifetimes = append(st.lifetimes, l.duration().Seconds())
+			st.total += l.duration().Seconds()
+		}
+	}
+
+	sorted := make([]*commandStats, 0, len(stats))
+	for _, st := range stats {
+		sorted = append(sorted, st)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].total != sorted[j].total {
+			return sorted[i].total > sorted[j].total
+		}
+		return sorted[i].command < sorted[j].command
+	})
+
+	seconds := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second)).Round(time.Millisecond)
+	}
+	wall := meanAndStddev(walls)
+	fmt.Fprintf(w, "runs:\t%d\n", len(runs))
+	fmt.Fprintf(w, "wall:\t%s ± %s\n", seconds(wall.mean), seconds(wall.stddev))
+	fmt.Fprintln(w, "invocations\tstddev\tlifetime\tstddev\truns\tcommand")
+	for _, st := range sorted {
+		invocations, lifetime := meanAndStddev(st.invocations), meanAndStddev(st.lifetimes)
+		fmt.Fprintf(w, "%.1f\t%.1f\t%s\t%s\t%d\t%s\n",
+			invocations.mean, invocations.stddev, seconds(lifetime.mean), seconds(lifetime.stddev), st.seen, st.command)
+	}
+	return nil
+}
+
+type moments struct {
+	mean, stddev float64
+}
+
+// meanAndStddev returns the mean and sample standard deviation of xs.
+func meanAndStddev(xs []float64) moments {
+	var m moments
+	if len(xs) == 0 {
+		return m
+	}
+	for _, x := range xs {
+		m.mean += x
+	}
+	m.mean /= float64(len(xs))
+	if len(xs) < 2 {
+		return m
+	}
+	var squares float64
+	for _, x := range xs {
+		squares += (x - m.mean) * (x - m.mean)
+	}
+	m.stddev = math.Sqrt(squares / float64(len(xs)-1))
+	return m
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// procLister enumerates the processes running on the machine. Implementations
+// only need to fill in the proc fields other than Children.
+type procLister interface {
+	listProcs() (map[int]proc, error)
+}
+
+// commandScoper is implemented by listers that can track the processes
+// belonging to the command directly (e.g. with a job object or cgroup),
+// rather than relying on the ppid tree alone.
+type commandScoper interface {
+	// scope starts tracking cmd, which has just been started.
+	scope(cmd *exec.Cmd) error
+	// scopedPids returns the pids currently tracked.
+	scopedPids() ([]int, error)
+}
+
+// commandPreparer is implemented by scopers that need to set the command up
+// before it starts, so that it's tracked before it can start any processes of
+// its own.
+type commandPreparer interface {
+	prepare(cmd *exec.Cmd) error
+}
+
+// sampleAnnotator is implemented by listers that can add information about
+// the command as a whole to each sample.
+type sampleAnnotator interface {
+	annotate(s *sample)
+}
+
+// psOptions configure the listers that parse the output of `ps`.
+type psOptions struct {
+	// columns are additional fields to capture into proc.Extra (-columns)
+	columns []string
+	// strict fails on unparsable lines instead of skipping them (-strict)
+	strict bool
+}
+
+// psOptionLister is implemented by listers that parse the output of `ps`.
+type psOptionLister interface {
+	withPsOptions(opts psOptions) procLister
+}
+
+// newProcLister returns the lister for the named backend, or the platform's
+// preferred one if name is empty. opts are ignored by backends that don't use
+// `ps`, unless they ask for extra columns.
+func newProcLister(name string, opts psOptions) (procLister, error) {
+	if name == "" {
+		name = defaultBackend
+	}
+	if strings.HasPrefix(name, fixtureBackend) {
+		if len(opts.columns) > 0 {
+			return nil, fmt.Errorf("the fixture backend doesn't support -columns")
+		}
+		return newFixtureLister(strings.TrimPrefix(name, fixtureBackend))
+	}
+	newLister, ok := procListers[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported backend %q (expected one of %s)", name, strings.Join(backendNames(), ", "))
+	}
+	lister := newLister()
+	pl, ok := lister.(psOptionLister)
+	if !ok {
+		if len(opts.columns) > 0 {
+			return nil, fmt.Errorf("the %s backend doesn't support -columns", name)
+		}
+		return lister, nil
+	}
+	return pl.withPsOptions(opts), nil
+}
+
+func backendNames() []string {
+	names := make([]string, 0, len(procListers))
+	for name := range procListers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
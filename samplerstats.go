@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// samplerStats measures how closely sampling kept to the requested interval,
+// and how much time was spent sampling rather than waiting.
+type samplerStats struct {
+	interval  time.Duration
+	count     int
+	first     time.Time
+	last      time.Time
+	busy      time.Duration
+	lastBusy  time.Duration
+	maxJitter time.Duration
+	// adaptive is set if interval changes with -adaptive, in which case
+	// it's the latest interval
+	adaptive bool
+}
+
+// record notes a sample that started at started and took busy to capture.
+func (s *samplerStats) record(started time.Time, busy time.Duration) {
+	if s.count == 0 {
+		s.first = started
+	} else {
+		jitter := started.Sub(s.last) - s.interval
+		if jitter < 0 {
+			jitter = -jitter
+		}
+		if jitter > s.maxJitter {
+			s.maxJitter = jitter
+		}
+	}
+	s.count++
+	s.last = started
+	s.busy += busy
+	s.lastBusy = busy
+}
+
+func (s *samplerStats) String() string {
+	if s.count < 2 {
+		return fmt.Sprintf("took %d samples", s.count)
+	}
+	elapsed := s.last.Sub(s.first)
+	achieved := float64(s.count-1) / elapsed.Seconds()
+	target := fmt.Sprintf("target %.1f Hz", float64(time.Second)/float64(s.interval))
+	if s.adaptive {
+		target = "adaptive"
+	}
+	overhead := s.busy.Seconds() / (elapsed + s.lastBusy).Seconds()
+	return fmt.Sprintf("took %d samples in %s: %.1f Hz (%s), max jitter %s, mean sample time %s (sampler busy %.0f%% of the time)",
+		s.count, elapsed.Round(time.Millisecond), achieved, target, s.maxJitter.Round(time.Microsecond), (s.busy / time.Duration(s.count)).Round(time.Microsecond), overhead*100)
+}
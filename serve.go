@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// runServe implements `pstree_prof serve run.ndjson`, serving every report on
+// a run recorded with -fmt ndjson at /<format>, with an index of them at /.
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s serve [flags] run.ndjson\n", NAME)
+		flags.PrintDefaults()
+	}
+	addr := flags.String("addr", "localhost:8080", "Address to serve the reports on")
+	rf := addReportFlags(flags, false)
+	parseFlags(flags, args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		log.Fatalln("expected exactly one recorded run")
+	}
+	r := rf.reporter(flags)
+	samples, err := readSamples(flags.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	r.opts.envKeys, r.opts.columns = recordedKeys(samples)
+	samples = r.prepare(samples)
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("could not serve reports: %s\n", err)
+	}
+	log.Printf("serving reports on %s at http://%s/\n", flags.Arg(0), l.Addr())
+	log.Fatalln(http.Serve(l, &reportServer{name: flags.Arg(0), samples: samples, opts: r.opts}))
+}
+
+// reportServer renders the reports on a recorded run on request.
+type reportServer struct {
+	name    string
+	samples []sample
+	opts    reportOptions
+}
+
+// servable reports whether the format named can be rendered on request:
+// stream formats are only written while sampling, and remote ones send the
+// samples elsewhere.
+func servable(name string) bool {
+	format := outputFormats[name]
+	return format.write != nil && !format.remote
+}
+
+func (s *reportServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(req.URL.Path, "/")
+	if name == "" {
+		s.serveIndex(w)
+		return
+	}
+	if _, ok := outputFormats[name]; !ok || !servable(name) {
+		http.NotFound(w, req)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := outputFormats[name].write(&buf, s.samples, s.opts); err != nil {
+		http.Error(w, fmt.Sprintf("could not write %s report: %s", name, err), http.StatusInternalServerError)
+		return
+	}
+	switch {
+	case outputFormats[name].binary:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	case name == "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Write(buf.Bytes())
+}
+
+func (s *reportServer) serveIndex(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<title>%s: %s</title>\n<h1>%s</h1>\n<ul>\n", NAME, html.EscapeString(s.name), html.EscapeString(s.name))
+	for _, name := range formatNames() {
+		if servable(name) {
+			fmt.Fprintf(w, "<li><a href=\"/%s\">%s</a>\n", name, name)
+		}
+	}
+	fmt.Fprintln(w, "</ul>")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// printTreeShape reports the deepest the process tree got, the process with
+// the most children at once, and the ancestry of the deepest process, which
+// helps to spot runaway recursive spawning.
+func printTreeShape(w io.Writer, samples []sample, opts reportOptions) error {
+	var deepest []proc
+	deepestAt := -1
+	var widest proc
+	widestChildren, widestAt := 0, -1
+
+	for i, s := range samples {
+		children := make(map[int]int)
+		for _, p := range s.Procs {
+			if _, ok := s.Procs[p.Ppid]; ok {
+				children[p.Ppid]++
+			}
+		}
+		for pid, n := range children {
+			if n > widestChildren {
+				widest, widestChildren, widestAt = s.Procs[pid], n, i
+			}
+		}
+		for pid := range s.Procs {
+			if chain := ancestry(s.Procs, pid); len(chain) > len(deepest) {
+				deepest, deepestAt = chain, i
+			}
+		}
+	}
+
+	if deepestAt < 0 {
+		fmt.Fprintln(w, "no processes were sampled")
+		return nil
+	}
+
+	fmt.Fprintf(w, "max depth:\t%d (sample %d)\n", len(deepest)-1, deepestAt)
+	if widestAt >= 0 {
+		fmt.Fprintf(w, "max fan-out:\t%d children of %d %s (sample %d)\n", widestChildren, widest.Pid, widest.Command, widestAt)
+	} else {
+		fmt.Fprintln(w, "max fan-out:\t0")
+	}
+	fmt.Fprintln(w, "deepest ancestry:")
+	for depth := len(deepest) - 1; depth >= 0; depth-- {
+		p := deepest[depth]
+		fmt.Fprintf(w, "%s%d %s\n", strings.Repeat("  ", len(deepest)-1-depth), p.Pid, p.Command)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// splitCommand tokenizes s the way a POSIX shell would split a simple
+// command: whitespace separates arguments, single quotes preserve everything
+// literally, and double quotes preserve everything except backslash escapes
+// of `"`, `\`, `$` and "`". Expansions and operators are not supported.
+func splitCommand(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+
+	const (
+		unquoted = iota
+		singleQuoted
+		doubleQuoted
+	)
+	state := unquoted
+	escaped := false
+
+	for _, c := range s {
+		if escaped {
+			if state == doubleQuoted && !strings.ContainsRune("\"\\$`", c) {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(c)
+			escaped = false
+			continue
+		}
+
+		switch state {
+		case singleQuoted:
+			if c == '\'' {
+				state = unquoted
+			} else {
+				arg.WriteRune(c)
+			}
+		case doubleQuoted:
+			switch c {
+			case '"':
+				state = unquoted
+			case '\\':
+				escaped = true
+			default:
+				arg.WriteRune(c)
+			}
+		default:
+			switch c {
+			case ' ', '\t', '\n':
+				if inArg {
+					args = append(args, arg.String())
+					arg.Reset()
+					inArg = false
+				}
+				continue
+			case '\'':
+				state = singleQuoted
+			case '"':
+				state = doubleQuoted
+			case '\\':
+				escaped = true
+			default:
+				arg.WriteRune(c)
+			}
+			inArg = true
+		}
+	}
+
+	if escaped {
+		return nil, fmt.Errorf("unterminated escape in %q", s)
+	}
+	if state != unquoted {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// printShortLived reports, for each normalized command, how many of its
+// processes were seen in no more than -short-lived samples. Their counts and
+// lifetimes are little more than a guess at the interval they were sampled
+// at, and at a lower rate processes like them are likely to be missed
+// entirely.
+func printShortLived(w io.Writer, samples []sample, opts reportOptions) error {
+	type shortLived struct {
+		command string
+		short   int
+		total   int
+	}
+	commands := make(map[string]*shortLived)
+	var short, total int
+	for _, l := range lifetimes(samples) {
+		key := normalizeCommand(l.proc.Command, opts.normalize)
+		sl, ok := commands[key]
+		if !ok {
+			sl = &shortLived{command: key}
+			commands[key] = sl
+		}
+		sl.total++
+		total++
+		if l.samples() <= opts.shortLived {
+			sl.short++
+			short++
+		}
+	}
+	if short == 0 {
+		fmt.Fprintf(w, "no processes were seen in %d or fewer samples\n", opts.shortLived)
+		return nil
+	}
+
+	sorted := make([]*shortLived, 0, len(commands))
+	for _, sl := range commands {
+		if sl.short > 0 {
+			sorted = append(sorted, sl)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].short != sorted[j].short {
+			return sorted[i].short > sorted[j].short
+		}
+		return sorted[i].command < sorted[j].command
+	})
+
+	fmt.Fprintln(w, "short_lived\tinvocations\tcommand")
+	for _, sl := range sorted {
+		fmt.Fprintf(w, "%d\t%d\t%s\n", sl.short, sl.total, sl.command)
+	}
+	fmt.Fprintf(w, "%d of %d processes were seen in %d or fewer samples; sample more often, or use -events, to see how long they really lived\n",
+		short, total, opts.shortLived)
+	return nil
+}
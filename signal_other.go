@@ -0,0 +1,60 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// setProcessGroup makes the command the leader of a new process group, so
+// that it and its descendants can be signalled without signalling us.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// forwardSignals passes interrupts on to the command's process group, which
+// no longer receives them from the terminal, so that it can exit and still be
+// reported on. stop undoes it.
+func forwardSignals(cmd *exec.Cmd) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				syscall.Kill(-cmd.Process.Pid, sig.(syscall.Signal))
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// terminateCommand asks the command and its descendants in procs to exit.
+func terminateCommand(cmd *exec.Cmd, procs map[int]proc) {
+	signalCommand(cmd, procs, syscall.SIGTERM)
+}
+
+// killCommand forcibly stops the command and its descendants in procs.
+func killCommand(cmd *exec.Cmd, procs map[int]proc) {
+	signalCommand(cmd, procs, syscall.SIGKILL)
+}
+
+// signalCommand sends sig to the command's process group and to each of
+// procs individually, since descendants may have moved to groups of their
+// own.
+func signalCommand(cmd *exec.Cmd, procs map[int]proc, sig syscall.Signal) {
+	syscall.Kill(-cmd.Process.Pid, sig)
+	for pid := range procs {
+		syscall.Kill(pid, sig)
+	}
+}
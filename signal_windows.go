@@ -0,0 +1,35 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup does nothing on Windows, where the lister's job object
+// already contains the command's descendants.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// forwardSignals does nothing on Windows, where console interrupts are
+// delivered to every process attached to the console.
+func forwardSignals(cmd *exec.Cmd) (stop func()) {
+	return func() {}
+}
+
+// terminateCommand stops the command and its descendants in procs. Windows
+// has no equivalent of SIGTERM for console processes, so they are killed.
+func terminateCommand(cmd *exec.Cmd, procs map[int]proc) {
+	killCommand(cmd, procs)
+}
+
+// killCommand forcibly stops the command and its descendants in procs.
+func killCommand(cmd *exec.Cmd, procs map[int]proc) {
+	cmd.Process.Kill()
+	for pid := range procs {
+		if p, err := os.FindProcess(pid); err == nil {
+			p.Kill()
+		}
+	}
+}
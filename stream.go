@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+)
+
+// sampleStream writes each sample as a line of JSON as soon as it's captured,
+// for -fmt stream. Lines are written unbuffered so that whatever is reading
+// (a pipe, a FIFO or a file being tailed) sees them straight away.
+type sampleStream struct {
+	closer io.Closer
+	enc    *json.Encoder
+	failed bool
+}
+
+// openSampleStream streams to the file at path, or to stdout if path is "".
+func openSampleStream(path string) (*sampleStream, error) {
+	if path == "" {
+		return &sampleStream{enc: json.NewEncoder(os.Stdout)}, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &sampleStream{closer: f, enc: json.NewEncoder(f)}, nil
+}
+
+// write streams s, giving up (with a warning) after the first error, e.g. if
+// the reader goes away.
+func (s *sampleStream) write(smpl sample) {
+	if s.failed {
+		return
+	}
+	if err := s.enc.Encode(smpl); err != nil {
+		log.Printf("stopped streaming samples: %s\n", err)
+		s.failed = true
+	}
+}
+
+func (s *sampleStream) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
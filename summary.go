@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// printSummary reports, for each normalized command (or chain of commands,
+// with -show-ancestry), how many times it was invoked, statistics about how
+// long each invocation lived, and the most instances that were running at
+// once.
+func printSummary(w io.Writer, samples []sample, opts reportOptions) error {
+	type summary struct {
+		command   string
+		durations []time.Duration
+		total     time.Duration
+		peak      int
+	}
+	summaries := make(map[string]*summary)
+	get := func(key string) *summary {
+		s, ok := summaries[key]
+		if !ok {
+			s = &summary{command: key}
+			summaries[key] = s
+		}
+		return s
+	}
+
+	normalize := func(command string) string {
+		return normalizeCommand(command, opts.normalize)
+	}
+	key := func(procs map[int]proc, p proc) string {
+		if opts.showAncestry {
+			return ancestryPath(procs, p, normalize)
+		}
+		return normalize(p.Command)
+	}
+
+	// a process keeps the key it started with, even if its ancestry changes
+	// later (when it's reparented, say), so that it's counted consistently
+	running := make([]map[string]int, len(samples))
+	for _, l := range lifetimes(samples) {
+		k := key(samples[l.first].Procs, l.proc)
+		s := get(k)
+		s.durations = append(s.durations, l.duration())
+		s.total += l.duration()
+		if l.weight == 0 {
+			// came and went between samples
+			continue
+		}
+		for i := l.first; i <= l.last; i++ {
+			if running[i] == nil {
+				running[i] = make(map[string]int)
+			}
+			running[i][k]++
+		}
+	}
+	for _, counts := range running {
+		for k, n := range counts {
+			if s := summaries[k]; n > s.peak {
+				s.peak = n
+			}
+		}
+	}
+
+	sorted := make([]*summary, 0, len(summaries))
+	for _, s := range summaries {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].total != sorted[j].total {
+			return sorted[i].total > sorted[j].total
+		}
+		return sorted[i].command < sorted[j].command
+	})
+
+	ms := time.Millisecond
+	fmt.Fprintln(w, "invocations\ttotal\tmean\tp95\tmax_concurrent\tcommand")
+	for _, s := range sorted {
+		if len(s.durations) == 0 {
+			continue
+		}
+		mean := s.total / time.Duration(len(s.durations))
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%s\n",
+			len(s.durations), s.total.Round(ms), mean.Round(ms), percentile(s.durations, 0.95).Round(ms), s.peak, s.command)
+	}
+	if i, ok := firstTimedOut(samples); ok {
+		fmt.Fprintf(w, "timed out at +%s (sample %d)\n", samples[i].At.Sub(samples[0].At).Round(ms), i)
+	}
+	return nil
+}
+
+// firstTimedOut returns the index of the first sample taken after -timeout
+// elapsed, if any.
+func firstTimedOut(samples []sample) (int, bool) {
+	for i, s := range samples {
+		if s.TimedOut {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// percentile returns the nearest-rank percentile p (0 < p <= 1) of durations,
+// which it sorts in place.
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	rank := int(math.Ceil(p * float64(len(durations))))
+	if rank < 1 {
+		rank = 1
+	}
+	return durations[rank-1]
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"time"
+)
+
+// svg layout, in pixels
+const (
+	svgLabelWidth = 320
+	svgChartWidth = 880
+	svgLaneHeight = 18
+	svgAxisHeight = 24
+)
+
+// printLifetimesAsSVG renders process lifetimes as a self-contained SVG
+// swimlane chart, with one lane per process and children listed beneath
+// their parents. Hovering over a bar shows the full command and timings, and
+// lines of output recorded with -tee are marked along the time axis.
+func printLifetimesAsSVG(w io.Writer, samples []sample, opts reportOptions) error {
+	ls := lifetimes(samples)
+	parents := parentLifetimes(ls)
+
+	// order the lanes depth first, so that each process follows its parent
+	children := make(map[int][]int)
+	var roots []int
+	for i, parent := range parents {
+		if parent < 0 {
+			roots = append(roots, i)
+		} else {
+			children[parent] = append(children[parent], i)
+		}
+	}
+	type lane struct {
+		lifetime int
+		depth    int
+	}
+	var lanes []lane
+	var visit func(i, depth int)
+	visit = func(i, depth int) {
+		lanes = append(lanes, lane{i, depth})
+		for _, child := range children[i] {
+			visit(child, depth+1)
+		}
+	}
+	for _, root := range roots {
+		visit(root, 0)
+	}
+
+	var begin time.Time
+	var total time.Duration
+	if len(samples) > 0 {
+		begin = samples[0].At
+		total = samples[len(samples)-1].At.Sub(begin)
+	}
+	for _, l := range ls {
+		// events and output may fall before the first sample or after the
+		// last
+		if l.start.Before(begin) {
+			total += begin.Sub(l.start)
+			begin = l.start
+		}
+		if d := l.end.Sub(begin); d > total {
+			total = d
+		}
+	}
+	for _, s := range samples {
+		for _, line := range s.Output {
+			if line.At.Before(begin) {
+				total += begin.Sub(line.At)
+				begin = line.At
+			}
+			if d := line.At.Sub(begin); d > total {
+				total = d
+			}
+		}
+	}
+	x := func(t time.Time) float64 {
+		if total <= 0 {
+			return svgLabelWidth
+		}
+		return svgLabelWidth + float64(t.Sub(begin))/float64(total)*svgChartWidth
+	}
+
+	width := svgLabelWidth + svgChartWidth + 10
+	height := svgAxisHeight + len(lanes)*svgLaneHeight + 10
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="monospace" font-size="11">`+"\n", width, height, width, height)
+	fmt.Fprintf(w, `<rect width="100%%" height="100%%" fill="white"/>`+"\n")
+	fmt.Fprintf(w, `<text x="%d" y="14">0s</text>`+"\n", svgLabelWidth)
+	fmt.Fprintf(w, `<text x="%d" y="14" text-anchor="end">%s</text>`+"\n", svgLabelWidth+svgChartWidth, total.Round(time.Millisecond))
+	// mark the lines recorded with -tee along the axis
+	for _, s := range samples {
+		for _, line := range s.Output {
+			color := "#888"
+			if line.Stream == "stderr" {
+				color = "#c33"
+			}
+			fmt.Fprintf(w, `<rect x="%.1f" y="17" width="1" height="6" fill="%s"><title>+%s %s (%d live): %s</title></rect>`+"\n",
+				x(line.At), color, line.At.Sub(begin).Round(time.Millisecond), line.Stream, line.Live, html.EscapeString(line.Text))
+		}
+	}
+	for row, ln := range lanes {
+		l := ls[ln.lifetime]
+		y := svgAxisHeight + row*svgLaneHeight
+		if row%2 == 1 {
+			fmt.Fprintf(w, `<rect x="0" y="%d" width="%d" height="%d" fill="#f4f4f4"/>`+"\n", y, width, svgLaneHeight)
+		}
+		label := fmt.Sprintf("%d %s", l.proc.Pid, l.proc.Command)
+		fmt.Fprintf(w, `<text x="%d" y="%d"><title>%s</title>%s</text>`+"\n",
+			4+ln.depth*10, y+13, html.EscapeString(label), html.EscapeString(truncateLabel(label, (svgLabelWidth-8-ln.depth*10)/7)))
+
+		start, end := x(l.start), x(l.end)
+		if end-start < 1 {
+			end = start + 1
+		}
+		fmt.Fprintf(w, `<rect x="%.1f" y="%d" width="%.1f" height="%d" fill="hsl(%d,60%%,55%%)"><title>%s&#10;+%s for %s, %d samples</title></rect>`+"\n",
+			start, y+3, end-start, svgLaneHeight-6, (ln.depth*47)%360, html.EscapeString(label),
+			l.start.Sub(begin).Round(time.Millisecond), l.duration().Round(time.Millisecond), l.samples())
+	}
+	fmt.Fprintln(w, "</svg>")
+	return nil
+}
+
+// truncateLabel shortens label to at most max characters (at roughly 7px per
+// monospace character), marking where it was cut with an ellipsis.
+func truncateLabel(label string, max int) string {
+	runes := []rune(label)
+	if len(runes) <= max || max < 1 {
+		return label
+	}
+	return string(runes[:max-1]) + "…"
+}
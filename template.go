@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateData is what a -template-file is executed with.
+type templateData struct {
+	// Start and End are the times of the first and last samples
+	Start, End time.Time
+	Duration   time.Duration
+	Samples    []sample
+	// Processes holds every process lifetime, ordered by when it was first
+	// seen, and Roots those of them whose parent was never observed
+	Processes []*templateProcess
+	Roots     []*templateProcess
+	// Commands aggregates the processes by normalized command
+	Commands []templateCommand
+}
+
+// templateProcess is a process lifetime with exported fields.
+type templateProcess struct {
+	proc
+	Samples    int
+	Start, End time.Time
+	Duration   time.Duration
+	// Parent is nil for roots
+	Parent *templateProcess
+	// Children shadows proc.Children, which holds pids
+	Children []*templateProcess
+}
+
+type templateCommand struct {
+	Command string
+	Samples int
+	Pids    int
+	Wall    time.Duration
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"ms": func(d time.Duration) time.Duration {
+		return d.Round(time.Millisecond)
+	},
+	"seconds": func(d time.Duration) float64 {
+		return d.Seconds()
+	},
+	"repeat": strings.Repeat,
+}
+
+// loadTemplate parses the -template-file at path. Besides the builtins,
+// templates can call join, repeat, ms (which rounds a duration to the
+// millisecond) and seconds.
+func loadTemplate(path string) (*template.Template, error) {
+	t, err := template.New(filepath.Base(path)).Funcs(templateFuncs).ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -template-file: %s", err)
+	}
+	return t, nil
+}
+
+// executeTemplate writes the report described by the -template-file.
+func executeTemplate(w io.Writer, samples []sample, opts reportOptions) error {
+	if opts.template == nil {
+		return fmt.Errorf("-fmt template requires -template-file")
+	}
+
+	data := templateData{Samples: samples}
+	if len(samples) > 0 {
+		data.Start, data.End = samples[0].At, samples[len(samples)-1].At
+		data.Duration = data.End.Sub(data.Start)
+	}
+	ls := lifetimes(samples)
+	for _, l := range ls {
+		data.Processes = append(data.Processes, &templateProcess{
+			proc:     l.proc,
+			Samples:  l.samples(),
+			Start:    l.start,
+			End:      l.end,
+			Duration: l.duration(),
+		})
+	}
+	for i, parent := range parentLifetimes(ls) {
+		p := data.Processes[i]
+		if parent < 0 {
+			data.Roots = append(data.Roots, p)
+			continue
+		}
+		p.Parent = data.Processes[parent]
+		p.Parent.Children = append(p.Parent.Children, p)
+	}
+	for _, g := range commandGroups(samples, opts.normalize) {
+		data.Commands = append(data.Commands, templateCommand{
+			Command: g.command,
+			Samples: g.samples,
+			Pids:    len(g.pids),
+			Wall:    g.wall,
+		})
+	}
+	return opts.template.Execute(w, data)
+}
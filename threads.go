@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// printThreadCounts reports the peak and average number of threads of each
+// command, averaged over the samples in which it was running.
+func printThreadCounts(w io.Writer, samples []sample, opts reportOptions) error {
+	type threadStats struct {
+		command string
+		peak    int
+		total   int
+		samples int
+	}
+	stats := make(map[string]*threadStats)
+	for _, s := range samples {
+		for _, p := range s.Procs {
+			if p.Threads == 0 {
+				continue
+			}
+			st, ok := stats[p.Command]
+			if !ok {
+				st = &threadStats{command: p.Command}
+				stats[p.Command] = st
+			}
+			if p.Threads > st.peak {
+				st.peak = p.Threads
+			}
+			st.total += p.Threads * s.weight()
+			st.samples += s.weight()
+		}
+	}
+
+	sorted := make([]*threadStats, 0, len(stats))
+	for _, st := range stats {
+		sorted = append(sorted, st)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].peak != sorted[j].peak {
+			return sorted[i].peak > sorted[j].peak
+		}
+		return sorted[i].command < sorted[j].command
+	})
+
+	fmt.Fprintln(w, "peak\tavg\tcommand")
+	for _, st := range sorted {
+		fmt.Fprintf(w, "%d\t%.1f\t%s\n", st.peak, float64(st.total)/float64(st.samples), st.command)
+	}
+	return nil
+}
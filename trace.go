@@ -2,60 +2,140 @@ package main
 
 import (
 	"context"
+	"io"
 	"log"
-	"os"
-	"time"
 
-	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
 	traceSDK "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
 	"go.opentelemetry.io/otel/trace"
 )
 
-func exportSamplesAsTraces(samples []sample) {
-	exporter, _ := stdouttrace.New(
-		stdouttrace.WithWriter(os.Stderr),
+func exportSamplesAsTraces(w io.Writer, samples []sample, opts reportOptions) error {
+	exporter, err := stdouttrace.New(
+		stdouttrace.WithWriter(w),
 		stdouttrace.WithPrettyPrint(),
 		stdouttrace.WithoutTimestamps(),
 	)
+	if err != nil {
+		return err
+	}
+	return exportSpans(exporter, samples, opts)
+}
+
+// exportSamplesOverOTLP pushes the spans to an OTLP/HTTP collector. Unless
+// -otlp-endpoint is given, the endpoint is taken from the standard
+// OTEL_EXPORTER_OTLP_* environment variables (default localhost:4318).
+func exportSamplesOverOTLP(w io.Writer, samples []sample, opts reportOptions) error {
+	var clientOpts []otlptracehttp.Option
+	if opts.otlpEndpoint != "" {
+		clientOpts = append(clientOpts, otlptracehttp.WithEndpoint(opts.otlpEndpoint))
+	}
+	if opts.otlpInsecure {
+		clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), clientOpts...)
+	if err != nil {
+		return err
+	}
+	if err := exportSpans(exporter, samples, opts); err != nil {
+		return err
+	}
+	log.Printf("exported %d process spans over OTLP\n", len(lifetimes(samples)))
+	return nil
+}
 
+// exportSpans sends one span per process lifetime, nested under the span of
+// its parent process, all beneath a root span covering the whole run.
+func exportSpans(exporter traceSDK.SpanExporter, samples []sample, opts reportOptions) (err error) {
+	recorder := &errRecordingExporter{SpanExporter: exporter}
 	tp := traceSDK.NewTracerProvider(
-		traceSDK.WithBatcher(exporter),
+		traceSDK.WithBatcher(recorder),
+		traceSDK.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(NAME),
+		)),
 	)
 	defer func() {
-		if err := tp.Shutdown(context.Background()); err != nil {
-			log.Fatal(err)
+		if shutdownErr := tp.Shutdown(context.Background()); err == nil {
+			err = shutdownErr
+		}
+		if err == nil {
+			err = recorder.err
 		}
 	}()
-	otel.SetTracerProvider(tp)
-
-	tracer := otel.Tracer(NAME)
-	ctx, execSpan := tracer.Start(context.Background(), "start")
-	defer execSpan.End()
-
-	procs := make(map[int]struct {
-		p         proc
-		startedAt time.Time
-	})
-	for i, sample := range samples {
-		for _, p := range sample.Procs {
-			_, seenBefore := procs[p.Pid]
-			if !seenBefore {
-				// proc started
-				procs[p.Pid] = struct {
-					p         proc
-					startedAt time.Time
-				}{p, sample.At}
-			}
+
+	if len(samples) == 0 {
+		return nil
+	}
+
+	tracer := tp.Tracer(NAME)
+	ctx, execSpan := tracer.Start(context.Background(), "start", trace.WithTimestamp(samples[0].At))
+	defer execSpan.End(trace.WithTimestamp(samples[len(samples)-1].At))
+	if i, ok := firstTimedOut(samples); ok {
+		execSpan.AddEvent("timed out", trace.WithTimestamp(samples[i].At))
+		execSpan.SetStatus(codes.Error, "timed out")
+	}
+	for _, s := range samples {
+		for _, line := range s.Output {
+			execSpan.AddEvent("output", trace.WithTimestamp(line.At), trace.WithAttributes(
+				attribute.String("pstree_prof.stream", line.Stream),
+				attribute.String("pstree_prof.text", line.Text),
+				attribute.Int("pstree_prof.live", line.Live),
+			))
+		}
+	}
+
+	ls := lifetimes(samples)
+	parents := parentLifetimes(ls)
+	spanCtxs := make([]context.Context, len(ls))
+	spans := make([]trace.Span, len(ls))
+	// lifetimes are ordered by start, so parents are always started before
+	// their children
+	for i, l := range ls {
+		parentCtx := ctx
+		if parents[i] >= 0 {
+			parentCtx = spanCtxs[parents[i]]
+		}
+		attrs := []attribute.KeyValue{
+			semconv.ProcessPIDKey.Int(l.proc.Pid),
+			attribute.Int("process.parent_pid", l.proc.Ppid),
+			attribute.Int("process.pgid", l.proc.Pgid),
+			semconv.ProcessOwnerKey.String(l.proc.User),
+			semconv.ProcessCommandLineKey.String(l.proc.Command),
+			attribute.Int("pstree_prof.samples", l.samples()),
 		}
-		for _, p := range procs {
-			_, procStillRunning := sample.Procs[p.p.Pid]
-			if !procStillRunning || i == len(samples)-1 {
-				// proc ended, send span
-				_, span := tracer.Start(ctx, p.p.Command, trace.WithTimestamp(p.startedAt))
-				span.End(trace.WithTimestamp(sample.At))
-				delete(procs, p.p.Pid)
+		for _, key := range opts.envKeys {
+			if value, ok := l.proc.Env[key]; ok {
+				attrs = append(attrs, attribute.String("process.env."+key, value))
 			}
 		}
+		spanCtxs[i], spans[i] = tracer.Start(parentCtx, l.proc.Command,
+			trace.WithTimestamp(l.start),
+			trace.WithAttributes(attrs...),
+		)
+	}
+	for i, l := range ls {
+		spans[i].End(trace.WithTimestamp(l.end))
+	}
+	return nil
+}
+
+// errRecordingExporter remembers the first export error, which the batching
+// span processor would otherwise only hand to the global otel error handler.
+type errRecordingExporter struct {
+	traceSDK.SpanExporter
+	err error
+}
+
+func (e *errRecordingExporter) ExportSpans(ctx context.Context, spans []traceSDK.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	if err != nil && e.err == nil {
+		e.err = err
 	}
+	return err
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// defaultWrappers are the commands collapsed by -collapse-wrappers unless
+// -wrappers says otherwise.
+const defaultWrappers = "sh -c,bash -c,env,xargs,time"
+
+// wrapper matches a command whose executable has the given base name and
+// whose leading arguments are args, e.g. `sh -c`.
+type wrapper struct {
+	name string
+	args []string
+}
+
+// parseWrappers parses a -wrappers value.
+func parseWrappers(value string) []wrapper {
+	var wrappers []wrapper
+	for _, item := range splitList(value) {
+		fields := strings.Fields(item)
+		wrappers = append(wrappers, wrapper{name: fields[0], args: fields[1:]})
+	}
+	return wrappers
+}
+
+func (w wrapper) matches(command string) bool {
+	fields := strings.Fields(command)
+	if len(fields) <= len(w.args) || filepath.Base(fields[0]) != w.name {
+		return false
+	}
+	for i, arg := range w.args {
+		if fields[i+1] != arg {
+			return false
+		}
+	}
+	return true
+}
+
+// collapseWrappers returns a copy of samples without the processes that only
+// wrap others, such as `sh -c` and `env`, so that reports are about the
+// commands doing the work. A process is a wrapper if it ever had children
+// while running a wrapper command, in which case it's removed from every
+// sample, and its children reparented to its nearest ancestor that isn't a
+// wrapper.
+func collapseWrappers(samples []sample, wrappers []wrapper) []sample {
+	if len(wrappers) == 0 {
+		return samples
+	}
+	isWrapper := func(p proc) bool {
+		for _, w := range wrappers {
+			if w.matches(p.Command) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// decided once per process, so that a wrapper isn't left in the
+	// samples from before it forked or after its children exited
+	type process struct {
+		pid     int
+		command string
+	}
+	wrapping := make(map[process]bool)
+	for _, s := range samples {
+		for pid, p := range s.Procs {
+			if len(p.Children) > 0 && isWrapper(p) {
+				wrapping[process{pid, p.Command}] = true
+			}
+		}
+	}
+
+	collapsed := make([]sample, len(samples))
+	for i, s := range samples {
+		removed := make(map[int]bool)
+		for pid, p := range s.Procs {
+			if wrapping[process{pid, p.Command}] {
+				removed[pid] = true
+			}
+		}
+		if len(removed) == 0 {
+			collapsed[i] = s
+			continue
+		}
+
+		parentOf := func(p proc) int {
+			ppid := p.Ppid
+			for seen := 0; removed[ppid] && seen < len(removed); seen++ {
+				ppid = s.Procs[ppid].Ppid
+			}
+			return ppid
+		}
+		procs := make(map[int]proc, len(s.Procs)-len(removed))
+		for pid, p := range s.Procs {
+			if removed[pid] {
+				continue
+			}
+			p.Ppid = parentOf(p)
+			p.Children = nil
+			procs[pid] = p
+		}
+		for pid, p := range procs {
+			if parent, ok := procs[p.Ppid]; ok && p.Ppid != pid {
+				parent.Children = append(parent.Children, pid)
+				procs[p.Ppid] = parent
+			}
+		}
+		s.Procs = procs
+		collapsed[i] = s
+	}
+	return collapsed
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// printZombies reports the parents whose children were left as zombies
+// (exited but not yet reaped), how many, and for how long, which usually
+// means the parent isn't waiting for its children.
+func printZombies(w io.Writer, samples []sample, opts reportOptions) error {
+	type zombieParent struct {
+		pid     int
+		command string
+		zombies int
+		// total is the time spent as a zombie summed over the parent's
+		// children, and longest the most any one of them spent
+		total, longest time.Duration
+		// peak is the most zombie children the parent had at once
+		peak int
+	}
+	parents := make(map[int]*zombieParent)
+	for _, l := range lifetimes(samples) {
+		for i := l.first; i <= l.last; i++ {
+			p := samples[i].Procs[l.proc.Pid]
+			if !p.Zombie {
+				continue
+			}
+			zp, ok := parents[p.Ppid]
+			if !ok {
+				zp = &zombieParent{pid: p.Ppid, command: "?"}
+				parents[p.Ppid] = zp
+			}
+			if parent, ok := samples[i].Procs[p.Ppid]; ok {
+				zp.command = parent.Command
+			}
+			d := l.end.Sub(samples[i].At)
+			zp.zombies++
+			zp.total += d
+			if d > zp.longest {
+				zp.longest = d
+			}
+			break
+		}
+	}
+	if len(parents) == 0 {
+		fmt.Fprintln(w, "no zombie processes were sampled")
+		return nil
+	}
+
+	for _, s := range samples {
+		running := make(map[int]int)
+		for _, p := range s.Procs {
+			if p.Zombie {
+				running[p.Ppid]++
+			}
+		}
+		for ppid, n := range running {
+			if zp := parents[ppid]; zp != nil && n > zp.peak {
+				zp.peak = n
+			}
+		}
+	}
+
+	sorted := make([]*zombieParent, 0, len(parents))
+	for _, zp := range parents {
+		sorted = append(sorted, zp)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].total != sorted[j].total {
+			return sorted[i].total > sorted[j].total
+		}
+		return sorted[i].pid < sorted[j].pid
+	})
+
+	ms := time.Millisecond
+	fmt.Fprintln(w, "zombies\tmax_concurrent\ttotal\tlongest\tppid\tparent")
+	for _, zp := range sorted {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%d\t%s\n",
+			zp.zombies, zp.peak, zp.total.Round(ms), zp.longest.Round(ms), zp.pid, zp.command)
+	}
+	return nil
+}